@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+type DrainStatus struct {
+	Draining bool  `json:"draining"`
+	Pending  int64 `json:"pending"`
+}
+
+// adminAuth only lets through requests bearing the admin token
+func (p *Proxy) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	expected := []byte("Bearer " + p.AdminToken)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			w.WriteHeader(401)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	respBytes, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(respBytes)))
+	w.WriteHeader(code)
+	w.Write(respBytes)
+}
+
+func (p *Proxy) isDraining() bool {
+	return atomic.LoadInt32(&p.drainedByAdmin) == 1 || atomic.LoadInt32(&p.drainedByFile) == 1
+}
+
+func (p *Proxy) drainStatus() *DrainStatus {
+	return &DrainStatus{
+		p.isDraining(),
+		atomic.LoadInt64(&p.inflight),
+	}
+}
+
+// handleDrain stops accepting new bundles, requests already being
+// forwarded are left to finish
+func (p *Proxy) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(404)
+		return
+	}
+
+	atomic.StoreInt32(&p.drainedByAdmin, 1)
+	writeJSON(w, 200, p.drainStatus())
+}
+
+// handleDrainStatus reports how many requests are still being handled,
+// orchestrators can poll it until pending reaches zero
+func (p *Proxy) handleDrainStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(404)
+		return
+	}
+
+	writeJSON(w, 200, p.drainStatus())
+}
+
+// watchDrainFile drains while DrainFile exists, so a shared volume or
+// config management can drain instances without admin calls. Intake
+// resumes when the file goes away, unless drain was set over admin.
+func (p *Proxy) watchDrainFile() {
+	for {
+		p.checkDrainFile()
+
+		if !p.sleep(p.DrainFileInterval) {
+			return
+		}
+	}
+}
+
+// checkDrainFile syncs the file drain with whether DrainFile exists
+func (p *Proxy) checkDrainFile() {
+	_, err := os.Stat(p.DrainFile)
+	exists := err == nil
+	if exists && atomic.CompareAndSwapInt32(&p.drainedByFile, 0, 1) {
+		fmt.Println("Drain file", p.DrainFile, "found, draining")
+	} else if !exists && atomic.CompareAndSwapInt32(&p.drainedByFile, 1, 0) {
+		if atomic.LoadInt32(&p.drainedByAdmin) == 1 {
+			fmt.Println("Drain file", p.DrainFile, "removed, staying drained by admin")
+		} else {
+			fmt.Println("Drain file", p.DrainFile, "removed, resuming intake")
+		}
+	}
+}
+
+// publicMux routes bundle submission and readiness. It is its own mux,
+// so neither admin routes nor expvar's /debug/vars on the default mux
+// are ever served publicly.
+func (p *Proxy) publicMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", p.handleRpc)
+	mux.HandleFunc("/ready", p.handleReady)
+	return mux
+}
+
+// adminMux routes the admin endpoints, each behind adminAuth
+func (p *Proxy) adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/drain", p.adminAuth(p.handleDrain))
+	mux.HandleFunc("/admin/drain/status", p.adminAuth(p.handleDrainStatus))
+	mux.HandleFunc("/admin/config", p.adminAuth(p.handleConfig))
+	mux.HandleFunc("/admin/stats", p.adminAuth(p.handleStats))
+	mux.HandleFunc("/admin/whitelist/allowShrink", p.adminAuth(p.handleAllowShrink))
+	// Counters stay on admin unless they have their own listener
+	if p.MetricsAddr == "" {
+		mux.HandleFunc("/admin/vars", p.adminAuth(expvar.Handler().ServeHTTP))
+	}
+	return mux
+}
+
+// serveAdmin serves the admin endpoints on AdminAddr
+func (p *Proxy) serveAdmin() {
+	listener, err := p.listen(p.AdminAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.Serve(listener, p.adminMux()))
+}
+
+// metricsMux routes the expvar counters, and nothing else
+func (p *Proxy) metricsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", expvar.Handler())
+	return mux
+}
+
+// serveMetrics serves the counters on MetricsAddr
+func (p *Proxy) serveMetrics() {
+	listener, err := p.listen(p.MetricsAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.Serve(listener, p.metricsMux()))
+}
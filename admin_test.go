@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemovingDrainFileKeepsAdminDrain(t *testing.T) {
+	p := newTestProxy("")
+	p.DrainFile = filepath.Join(t.TempDir(), "drain")
+
+	err := ioutil.WriteFile(p.DrainFile, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.checkDrainFile()
+	if !p.isDraining() {
+		t.Fatal("not draining with the drain file present")
+	}
+
+	w := httptest.NewRecorder()
+	p.handleDrain(w, httptest.NewRequest("POST", "/admin/drain", nil))
+	if w.Code != 200 {
+		t.Fatalf("drain status %d", w.Code)
+	}
+
+	os.Remove(p.DrainFile)
+	p.checkDrainFile()
+	if !p.isDraining() {
+		t.Fatal("removing the drain file undid the admin drain")
+	}
+}
+
+func TestRemovingDrainFileResumesIntake(t *testing.T) {
+	p := newTestProxy("")
+	p.DrainFile = filepath.Join(t.TempDir(), "drain")
+
+	p.checkDrainFile()
+	if p.isDraining() {
+		t.Fatal("draining without a drain file")
+	}
+
+	err := ioutil.WriteFile(p.DrainFile, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.checkDrainFile()
+	if !p.isDraining() {
+		t.Fatal("not draining with the drain file present")
+	}
+
+	os.Remove(p.DrainFile)
+	p.checkDrainFile()
+	if p.isDraining() {
+		t.Fatal("still draining after the drain file was removed")
+	}
+}
+
+// adminRequest sends a request to the admin mux, with token if set
+func adminRequest(p *Proxy, method string, path string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	p.adminMux().ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	p := newTestProxy("")
+	p.AdminToken = "secret"
+
+	for _, path := range []string{"/admin/drain/status", "/admin/config", "/admin/stats", "/admin/vars"} {
+		if w := adminRequest(p, "GET", path, ""); w.Code != 401 {
+			t.Errorf("%s without a token: status %d", path, w.Code)
+		}
+		if w := adminRequest(p, "GET", path, "wrong"); w.Code != 401 {
+			t.Errorf("%s with a wrong token: status %d", path, w.Code)
+		}
+		if w := adminRequest(p, "GET", path, "secret"); w.Code != 200 {
+			t.Errorf("%s: status %d", path, w.Code)
+		}
+	}
+	if w := adminRequest(p, "POST", "/admin/drain", "wrong"); w.Code != 401 || p.isDraining() {
+		t.Fatalf("drained with a wrong token: status %d", w.Code)
+	}
+}
+
+func TestAdminAndMetricsOnlyOnTheirOwnMux(t *testing.T) {
+	p := newTestProxy("")
+	p.AdminToken = "secret"
+
+	for _, path := range []string{"/admin/drain", "/admin/drain/status", "/admin/config", "/admin/stats", "/admin/vars", "/metrics", "/debug/vars"} {
+		for _, method := range []string{"GET", "POST"} {
+			r := httptest.NewRequest(method, path, nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			p.publicMux().ServeHTTP(w, r)
+			if w.Code != 404 {
+				t.Errorf("%s %s served publicly: status %d", method, path, w.Code)
+			}
+		}
+	}
+	if p.isDraining() {
+		t.Fatal("drained over the public mux")
+	}
+
+	for _, path := range []string{"/admin/drain/status", "/admin/config", "/admin/stats", "/admin/vars"} {
+		if w := adminRequest(p, "GET", path, "secret"); w.Code != 200 {
+			t.Errorf("%s on the admin mux: status %d", path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	p.metricsMux().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 {
+		t.Fatalf("/metrics on the metrics mux: status %d", w.Code)
+	}
+}
+
+func TestDrainEndpoints(t *testing.T) {
+	forwarding := make(chan struct{}, 1)
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarding <- struct{}{}
+		<-release
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.AdminToken = "secret"
+
+	if w := adminRequest(p, "GET", "/admin/drain", "secret"); w.Code != 404 {
+		t.Fatalf("GET drain: status %d", w.Code)
+	}
+
+	// a bundle held at the upstream while the drain starts
+	sent := make(chan *httptest.ResponseRecorder)
+	go func() {
+		sent <- postRpc(t, p, "eth_sendBundle", testBundle, testKey)
+	}()
+	<-forwarding
+
+	w := adminRequest(p, "POST", "/admin/drain", "secret")
+	status := &DrainStatus{}
+	json.Unmarshal(w.Body.Bytes(), status)
+	if w.Code != 200 || !status.Draining || status.Pending != 1 {
+		t.Fatalf("drain: status %d %s", w.Code, w.Body.String())
+	}
+
+	w = postRpc(t, p, "eth_sendBundle", testBundle, testKey)
+	if w.Code != 503 {
+		t.Fatalf("bundle accepted while draining: status %d", w.Code)
+	}
+
+	w = adminRequest(p, "GET", "/admin/drain/status", "secret")
+	json.Unmarshal(w.Body.Bytes(), status)
+	if !status.Draining || status.Pending != 1 {
+		t.Fatalf("drain status %s, want the held bundle pending", w.Body.String())
+	}
+
+	close(release)
+	if w := <-sent; w.Code != 200 {
+		t.Fatalf("held bundle: status %d", w.Code)
+	}
+	w = adminRequest(p, "GET", "/admin/drain/status", "secret")
+	json.Unmarshal(w.Body.Bytes(), status)
+	if !status.Draining || status.Pending != 0 {
+		t.Fatalf("drain status %s after the held bundle finished", w.Body.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.AdminToken = "secret"
+
+	getStats := func() *Stats {
+		w := adminRequest(p, "GET", "/admin/stats", "secret")
+		stats := &Stats{}
+		err := json.Unmarshal(w.Body.Bytes(), stats)
+		if w.Code != 200 || err != nil {
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+		return stats
+	}
+
+	before := getStats()
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	postRpc(t, p, "eth_sendBundle", testBundle, otherKey)
+	postRpc(t, p, "eth_sendBundle", testBundle, nil)
+	after := getStats()
+
+	if after.Received-before.Received != 3 || after.Accepted-before.Accepted != 1 || after.Dispatched-before.Dispatched != 1 {
+		t.Fatalf("before %+v after %+v", before, after)
+	}
+	if after.Rejected["notWhitelisted"]-before.Rejected["notWhitelisted"] != 1 ||
+		after.Rejected["signature:missing"]-before.Rejected["signature:missing"] != 1 {
+		t.Fatalf("rejected before %v after %v", before.Rejected, after.Rejected)
+	}
+	if after.Uptime <= 0 || after.WhitelistAge != -1 {
+		t.Fatalf("got uptime %f whitelistAge %f", after.Uptime, after.WhitelistAge)
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	p := newTestProxy("")
+	p.AdminToken = "secret"
+	p.MetricsAddr = "127.0.0.1:0"
+
+	w := httptest.NewRecorder()
+	p.metricsMux().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	vars := make(map[string]json.RawMessage)
+	err := json.Unmarshal(w.Body.Bytes(), &vars)
+	if w.Code != 200 || err != nil {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := vars["requests"]; !ok {
+		t.Fatal("counters missing from /metrics")
+	}
+
+	for _, path := range []string{"/admin/stats", "/admin/drain/status", "/debug/vars"} {
+		w := httptest.NewRecorder()
+		p.metricsMux().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != 404 {
+			t.Errorf("%s served on the metrics listener: status %d", path, w.Code)
+		}
+	}
+
+	// counters move off admin when they have their own listener
+	if w := adminRequest(p, "GET", "/admin/vars", "secret"); w.Code != 404 {
+		t.Fatalf("/admin/vars with metricsAddr set: status %d", w.Code)
+	}
+}
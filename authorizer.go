@@ -0,0 +1,14 @@
+package main
+
+import "context"
+
+// Authorizer is consulted after the whitelist check for operators with
+// their own admission rules, a request must pass both. Calls are made
+// synchronously on the request goroutine. Requests with no verified sender,
+// with signatureMode off or unsigned in optional mode, are not passed to
+// it, so addr is never empty.
+type Authorizer interface {
+	// Authorize reports whether addr may send req, an error rejects the
+	// request as well
+	Authorize(ctx context.Context, addr string, req *RpcReq) (bool, error)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+type denyAll struct {
+	addrs []string
+}
+
+func (a *denyAll) Authorize(ctx context.Context, addr string, req *RpcReq) (bool, error) {
+	a.addrs = append(a.addrs, addr)
+	return false, nil
+}
+
+func TestAuthorizerDeniesVerifiedSenders(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		t.Error("denied bundle forwarded")
+		return nil
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	authorizer := &denyAll{}
+	p.Authorizer = authorizer
+	p.EchoSender = true
+
+	w := postRpc(t, p, "eth_sendBundle", testBundle, testKey)
+	if w.Code != 400 || w.Body.String() != "Sender not authorized: "+keyAddr(t, testKey) {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	if len(authorizer.addrs) != 1 || authorizer.addrs[0] != keyAddr(t, testKey) {
+		t.Fatalf("authorizer saw %v", authorizer.addrs)
+	}
+}
+
+func TestAuthorizerSkippedWithoutSender(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	for _, mode := range []string{"off", "optional"} {
+		p := newTestProxy(upstream.URL)
+		authorizer := &denyAll{}
+		p.Authorizer = authorizer
+		p.SignatureMode = mode
+
+		resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, nil))
+		if resp.Error != nil {
+			t.Errorf("%s: got %+v", mode, resp.Error)
+		}
+		if len(authorizer.addrs) != 0 {
+			t.Errorf("%s: authorizer called with %q", mode, authorizer.addrs)
+		}
+	}
+}
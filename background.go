@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// spawn runs a background poller, tracked so Stop can wait for it
+func (p *Proxy) spawn(poller func()) {
+	p.pollers.Add(1)
+	go func() {
+		defer p.pollers.Done()
+		poller()
+	}()
+}
+
+func (p *Proxy) stopped() chan struct{} {
+	p.stopOnce.Do(func() {
+		p.stop = make(chan struct{})
+	})
+	return p.stop
+}
+
+// sleep waits for d, returning false early if the proxy is stopping
+func (p *Proxy) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-p.stopped():
+		return false
+	}
+}
+
+// stopContext returns a context cancelled once Stop is called, for the
+// fetches of background pollers
+func (p *Proxy) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-p.stopped():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Stop signals all background pollers to exit and waits until they have.
+// A fetch in progress is cancelled, so no poller outlives Stop by more
+// than it takes to notice.
+func (p *Proxy) Stop() {
+	stop := p.stopped()
+	p.closeOnce.Do(func() {
+		close(stop)
+	})
+	p.pollers.Wait()
+}
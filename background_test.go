@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stopWithin fails the test unless Stop returns promptly
+func stopWithin(t *testing.T, p *Proxy) {
+	t.Helper()
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+}
+
+func TestStopEndsSleepingPollers(t *testing.T) {
+	fetched := make(chan struct{}, 16)
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		fetched <- struct{}{}
+		return "0x10"
+	})
+	redirectDefaultClient(t, newSubgraph(t, false, "0x0000000000000000000000000000000000000001"))
+	p := newTestProxy(upstream.URL)
+	p.HeadPollInterval = time.Hour
+	p.WhitelistRefreshInterval = time.Hour
+	p.DrainFile = filepath.Join(t.TempDir(), "drain")
+	p.DrainFileInterval = time.Hour
+	p.MirrorAddr = upstream.URL
+	p.mirrorQueue = make(chan []byte, mirrorQueueSize)
+
+	p.spawn(p.pollHead)
+	p.spawn(p.refreshWhitelist)
+	p.spawn(p.watchDrainFile)
+	p.spawn(p.runMirror)
+	<-fetched
+	for atomic.LoadInt64(&p.whitelistUpdated) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stopWithin(t, p)
+
+	// stopping twice is fine
+	p.Stop()
+	if p.sleep(time.Hour) {
+		t.Fatal("sleep after Stop did not return early")
+	}
+}
+
+func TestStopCancelsPollersMidFetch(t *testing.T) {
+	started := make(chan string, 16)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- r.URL.Path
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	redirectDefaultClient(t, upstream)
+	p := newTestProxy(upstream.URL + "/rpc")
+	p.HeadPollInterval = time.Hour
+	p.MirrorAddr = upstream.URL + "/mirror"
+	p.mirrorQueue = make(chan []byte, mirrorQueueSize)
+	p.mirrorQueue <- []byte("{}")
+
+	p.spawn(p.pollHead)
+	p.spawn(p.refreshWhitelist)
+	p.spawn(p.runMirror)
+	blocked := make(map[string]bool)
+	for len(blocked) < 3 {
+		select {
+		case path := <-started:
+			blocked[path] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %v reached the upstream", blocked)
+		}
+	}
+
+	stopWithin(t, p)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxSignedBlockLead is how many blocks a signedBlock may be ahead of the
+// polled head, as relays can see new blocks before the next head poll
+const maxSignedBlockLead = 2
+
+// BundleArgs holds the eth_sendBundle fields the proxy inspects, the rest
+// of the bundle is forwarded untouched
+type BundleArgs struct {
+	BlockNumber  json.RawMessage
+	MinTimestamp *int64
+	MaxTimestamp *int64
+	// Head block the relay saw when signing, bounds the signature's age
+	SignedBlock json.RawMessage
+	// Sent as [txs, blockNumber, minTimestamp, maxTimestamp] rather than
+	// as a bundle object
+	positional bool
+}
+
+// parseBundleArgs reads the inspected fields from either form the upstream
+// accepts, [{"txs": ..., "blockNumber": ...}] or positional [txs,
+// blockNumber, minTimestamp, maxTimestamp]. Timestamps may be JSON numbers
+// or hex strings.
+func parseBundleArgs(params json.RawMessage) (*BundleArgs, error) {
+	var raw []json.RawMessage
+	err := json.Unmarshal(params, &raw)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("expected a bundle")
+	}
+
+	var fields map[string]json.RawMessage
+	var minTimestamp, maxTimestamp json.RawMessage
+	args := &BundleArgs{}
+	if json.Unmarshal(raw[0], &fields) == nil && fields != nil {
+		args.BlockNumber = fields["blockNumber"]
+		args.SignedBlock = fields["signedBlock"]
+		minTimestamp = fields["minTimestamp"]
+		maxTimestamp = fields["maxTimestamp"]
+	} else {
+		args.positional = true
+		if len(raw) > 1 {
+			args.BlockNumber = raw[1]
+		}
+		if len(raw) > 2 {
+			minTimestamp = raw[2]
+		}
+		if len(raw) > 3 {
+			maxTimestamp = raw[3]
+		}
+	}
+	if !present(args.SignedBlock) {
+		args.SignedBlock = nil
+	}
+
+	args.MinTimestamp, err = parseTimestamp(minTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("minTimestamp: %s", err)
+	}
+	args.MaxTimestamp, err = parseTimestamp(maxTimestamp)
+	if err != nil {
+		return nil, fmt.Errorf("maxTimestamp: %s", err)
+	}
+
+	return args, nil
+}
+
+// present reports whether an optional field was given a value
+func present(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// parseTimestamp accepts a timestamp as a JSON integer or a 0x prefixed
+// hex string, nil if absent
+func parseTimestamp(raw json.RawMessage) (*int64, error) {
+	if !present(raw) {
+		return nil, nil
+	}
+
+	var hexStr string
+	if json.Unmarshal(raw, &hexStr) == nil {
+		if len(hexStr) < 3 || hexStr[:2] != "0x" {
+			return nil, fmt.Errorf("timestamp %q is not 0x prefixed hex", hexStr)
+		}
+		timestamp, err := strconv.ParseUint(hexStr[2:], 16, 64)
+		if err != nil || timestamp > math.MaxInt64 {
+			return nil, fmt.Errorf("timestamp %q is out of range", hexStr)
+		}
+		signed := int64(timestamp)
+		return &signed, nil
+	}
+
+	var timestamp int64
+	err := json.Unmarshal(raw, &timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp %s is not an integer or hex", raw)
+	}
+	return &timestamp, nil
+}
+
+// admitBundle applies the stale block policy and admission rules. Bundles
+// are only parsed when one of those is enabled, otherwise they go to the
+// upstream exactly as the relay sent them.
+func (p *Proxy) admitBundle(req *RpcReq) error {
+	if !p.RequireTimestamps && !p.CheckTimestampWindow && !p.StrictBlockTarget &&
+		p.MaxSignatureAge == 0 && p.StaleBlockPolicy == "" {
+		return nil
+	}
+
+	args, err := parseBundleArgs(req.Params)
+	if err != nil {
+		return err
+	}
+	err = p.applyStaleBlockPolicy(req, args)
+	if err != nil {
+		return err
+	}
+	return p.validateBundle(args)
+}
+
+// applyStaleBlockPolicy handles a bundle targeting the current head, which
+// can only land in a later block. Retargeting rewrites the forwarded params.
+func (p *Proxy) applyStaleBlockPolicy(req *RpcReq, args *BundleArgs) error {
+	if p.StaleBlockPolicy == "" {
+		return nil
+	}
+	head := p.Head()
+	if head == 0 {
+		return nil
+	}
+	// Malformed block numbers are left to validateBundle
+	blockNumber, err := parseBlockNumber(args.BlockNumber)
+	if err != nil || blockNumber != head {
+		return nil
+	}
+
+	if p.StaleBlockPolicy == "reject" {
+		return fmt.Errorf("blockNumber %d is the current head, target %d or later", head, head+1)
+	}
+
+	target := json.RawMessage(fmt.Sprintf("\"0x%x\"", head+1))
+	var raw []json.RawMessage
+	err = json.Unmarshal(req.Params, &raw)
+	if err != nil {
+		return err
+	}
+	if args.positional {
+		raw[1] = target
+	} else {
+		var bundle map[string]json.RawMessage
+		err = json.Unmarshal(raw[0], &bundle)
+		if err != nil {
+			return err
+		}
+		bundle["blockNumber"] = target
+		raw[0], err = json.Marshal(bundle)
+		if err != nil {
+			return err
+		}
+	}
+	params, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	req.Params = params
+	args.BlockNumber = target
+	return nil
+}
+
+// validateBundle checks a bundle against the configured admission rules
+func (p *Proxy) validateBundle(args *BundleArgs) error {
+	if p.RequireTimestamps && (args.MinTimestamp == nil || args.MaxTimestamp == nil) {
+		return fmt.Errorf("minTimestamp and maxTimestamp are required")
+	}
+
+	if p.CheckTimestampWindow {
+		if (args.MinTimestamp != nil && *args.MinTimestamp < 0) ||
+			(args.MaxTimestamp != nil && *args.MaxTimestamp < 0) {
+			return fmt.Errorf("timestamps must not be negative")
+		}
+		// An inverted window can never be valid
+		if args.MinTimestamp != nil && args.MaxTimestamp != nil &&
+			*args.MinTimestamp > *args.MaxTimestamp {
+			return fmt.Errorf("minTimestamp is after maxTimestamp")
+		}
+	}
+
+	if p.StrictBlockTarget {
+		blockNumber, err := parseBlockNumber(args.BlockNumber)
+		if err != nil {
+			return fmt.Errorf("blockNumber: %s", err)
+		}
+		head := p.Head()
+		if head == 0 {
+			return fmt.Errorf("chain head unknown, cannot check blockNumber")
+		}
+		if blockNumber != head+1 {
+			return fmt.Errorf("blockNumber %d does not match the target block %d", blockNumber, head+1)
+		}
+	}
+
+	if p.MaxSignatureAge > 0 {
+		// The positional form has no slot for it
+		if args.positional {
+			return fmt.Errorf("signedBlock is required, send the bundle as an object to include it")
+		}
+		if args.SignedBlock == nil {
+			return fmt.Errorf("signedBlock is required")
+		}
+		signedBlock, err := parseBlockNumber(args.SignedBlock)
+		if err != nil {
+			return fmt.Errorf("signedBlock: %s", err)
+		}
+		head := p.Head()
+		if head == 0 {
+			return fmt.Errorf("chain head unknown, cannot check signedBlock")
+		}
+		// The relay may have seen a block or two more than the last poll
+		if signedBlock > head+maxSignedBlockLead {
+			return fmt.Errorf("signedBlock %d is ahead of head %d", signedBlock, head)
+		}
+		if signedBlock < head && head-signedBlock > p.MaxSignatureAge {
+			return fmt.Errorf("signedBlock %d is more than %d blocks behind head %d", signedBlock, p.MaxSignatureAge, head)
+		}
+	}
+
+	return nil
+}
+
+func invalidParams(req *RpcReq, err error) *RpcResp {
+	return &RpcResp{
+		"2.0",
+		nil,
+		&RpcErr{
+			-32602,
+			"Invalid params: " + err.Error(),
+			nil,
+		},
+		req.Id,
+	}
+}
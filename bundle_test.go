@@ -0,0 +1,243 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateBundleSignatureAge(t *testing.T) {
+	p := newTestProxy("")
+	p.MaxSignatureAge = 5
+	atomic.StoreUint64(&p.head, 100)
+
+	cases := []struct {
+		signedBlock string
+		wantErr     string
+	}{
+		{`"0x64"`, ""},                    // the head itself
+		{`95`, ""},                        // exactly MaxSignatureAge behind
+		{`94`, "behind"},                  // one too old
+		{`102`, ""},                       // within maxSignedBlockLead
+		{`103`, "ahead"},                  // dated past the lead
+		{`"0xffffffffffffffff"`, "ahead"}, // would overflow signedBlock+MaxSignatureAge
+	}
+	for _, c := range cases {
+		args, err := parseBundleArgs([]byte(`[{"blockNumber":"0x65","signedBlock":` + c.signedBlock + `}]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.validateBundle(args)
+		if c.wantErr == "" && err != nil {
+			t.Errorf("signedBlock %s: %s", c.signedBlock, err)
+		}
+		if c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)) {
+			t.Errorf("signedBlock %s: got %v, want %q", c.signedBlock, err, c.wantErr)
+		}
+	}
+
+	args, err := parseBundleArgs([]byte(`[["0x01"],"0x65",0,0,100]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.validateBundle(args)
+	if err == nil || !strings.Contains(err.Error(), "as an object") {
+		t.Fatalf("positional bundle: got %v", err)
+	}
+}
+
+func TestParseBundleArgsForms(t *testing.T) {
+	cases := []struct {
+		params     string
+		block      string
+		min, max   int64
+		positional bool
+	}{
+		{`[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":5,"maxTimestamp":9}]`, `"0x10"`, 5, 9, false},
+		{`[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":"0x5","maxTimestamp":"0x9"}]`, `"0x10"`, 5, 9, false},
+		{`[["0x01"],"0x10",5,9]`, `"0x10"`, 5, 9, true},
+		{`[["0x01"],"0x10"]`, `"0x10"`, -1, -1, true},
+		{`[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":null}]`, `"0x10"`, -1, -1, false},
+	}
+	for _, c := range cases {
+		args, err := parseBundleArgs([]byte(c.params))
+		if err != nil {
+			t.Errorf("%s: %s", c.params, err)
+			continue
+		}
+		if string(args.BlockNumber) != c.block || args.positional != c.positional {
+			t.Errorf("%s: got block %s positional %v", c.params, args.BlockNumber, args.positional)
+		}
+		for _, ts := range []struct {
+			got  *int64
+			want int64
+		}{{args.MinTimestamp, c.min}, {args.MaxTimestamp, c.max}} {
+			if (ts.want == -1) != (ts.got == nil) || (ts.got != nil && *ts.got != ts.want) {
+				t.Errorf("%s: got timestamp %v, want %d", c.params, ts.got, ts.want)
+			}
+		}
+	}
+
+	for _, params := range []string{`{}`, `[]`, `[{"minTimestamp":1.5}]`, `[{"maxTimestamp":"12"}]`} {
+		if _, err := parseBundleArgs([]byte(params)); err == nil {
+			t.Errorf("%s: parsed", params)
+		}
+	}
+}
+
+func TestBundlesAreForwardedUnparsedWithoutChecks(t *testing.T) {
+	var forwarded string
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarded = string(req.Params)
+		return "ok"
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+
+	// not a shape the proxy understands, left for the upstream to judge
+	params := `[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":1.5}]`
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", params, testKey))
+	if resp.Error != nil {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	if forwarded != params {
+		t.Fatalf("forwarded %s", forwarded)
+	}
+}
+
+func TestTimestampWindowChecks(t *testing.T) {
+	p := newTestProxy("")
+	p.CheckTimestampWindow = true
+	cases := []struct {
+		params  string
+		require bool
+		wantErr bool
+	}{
+		{`[{"blockNumber":"0x10","minTimestamp":5,"maxTimestamp":9}]`, false, false},
+		{`[{"blockNumber":"0x10","minTimestamp":9,"maxTimestamp":5}]`, false, true},
+		{`[{"blockNumber":"0x10","minTimestamp":-1}]`, false, true},
+		{`[{"blockNumber":"0x10"}]`, false, false},
+		{`[{"blockNumber":"0x10"}]`, true, true},
+		{`[{"blockNumber":"0x10","minTimestamp":5}]`, true, true},
+		{`[["0x01"],"0x10",5,9]`, true, false},
+	}
+	for _, c := range cases {
+		p.RequireTimestamps = c.require
+		err := p.admitBundle(&RpcReq{"2.0", "eth_sendBundle", []byte(c.params), nil})
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s requireTimestamps=%v: got %v", c.params, c.require, err)
+		}
+	}
+}
+
+func TestRequireTimestampsOverRpc(t *testing.T) {
+	var forwarded int
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarded++
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.RequireTimestamps = true
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error == nil || resp.Error.Code != -32602 || !strings.Contains(resp.Error.Message, "minTimestamp and maxTimestamp are required") {
+		t.Fatalf("got %+v, want invalid params", resp.Error)
+	}
+	if forwarded != 0 {
+		t.Fatal("bundle without timestamps was forwarded")
+	}
+
+	params := `[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":5,"maxTimestamp":"0x9"}]`
+	resp = decodeResp(t, postRpc(t, p, "eth_sendBundle", params, testKey))
+	if resp.Error != nil || forwarded != 1 {
+		t.Fatalf("got %+v, forwarded %d", resp.Error, forwarded)
+	}
+}
+
+func TestStrictBlockTarget(t *testing.T) {
+	p := newTestProxy("")
+	p.StrictBlockTarget = true
+	req := func(block string) *RpcReq {
+		return &RpcReq{"2.0", "eth_sendBundle", []byte(`[{"txs":["0x01"],"blockNumber":` + block + `}]`), nil}
+	}
+
+	err := p.admitBundle(req(`"0x11"`))
+	if err == nil || !strings.Contains(err.Error(), "head unknown") {
+		t.Fatalf("got %v with no head", err)
+	}
+
+	atomic.StoreUint64(&p.head, 0x10)
+	for block, wantErr := range map[string]bool{`"0x11"`: false, `17`: false, `"0x10"`: true, `"0x12"`: true, `"soon"`: true} {
+		err := p.admitBundle(req(block))
+		if (err != nil) != wantErr {
+			t.Errorf("blockNumber %s: got %v", block, err)
+		}
+	}
+}
+
+func TestStaleBlockPolicy(t *testing.T) {
+	p := newTestProxy("")
+	atomic.StoreUint64(&p.head, 0x10)
+
+	p.StaleBlockPolicy = "reject"
+	err := p.admitBundle(&RpcReq{"2.0", "eth_sendBundle", []byte(`[{"txs":["0x01"],"blockNumber":"0x10"}]`), nil})
+	if err == nil || !strings.Contains(err.Error(), "current head") {
+		t.Fatalf("got %v", err)
+	}
+	if err := p.admitBundle(&RpcReq{"2.0", "eth_sendBundle", []byte(`[{"txs":["0x01"],"blockNumber":"0x11"}]`), nil}); err != nil {
+		t.Fatalf("next block rejected: %s", err)
+	}
+
+	p.StaleBlockPolicy = "retarget"
+	for params, want := range map[string]string{
+		`[{"txs":["0x01"],"blockNumber":"0x10"}]`: `[{"blockNumber":"0x11","txs":["0x01"]}]`,
+		`[["0x01"],"0x10"]`:                       `[["0x01"],"0x11"]`,
+		`[{"txs":["0x01"],"blockNumber":"0x12"}]`: `[{"txs":["0x01"],"blockNumber":"0x12"}]`,
+	} {
+		req := &RpcReq{"2.0", "eth_sendBundle", []byte(params), nil}
+		err := p.admitBundle(req)
+		if err != nil || string(req.Params) != want {
+			t.Errorf("%s: got %s %v, want %s", params, req.Params, err, want)
+		}
+	}
+
+	// nothing to compare against before the head is known
+	p.StaleBlockPolicy = "reject"
+	atomic.StoreUint64(&p.head, 0)
+	if err := p.admitBundle(&RpcReq{"2.0", "eth_sendBundle", []byte(`[{"txs":["0x01"],"blockNumber":"0x10"}]`), nil}); err != nil {
+		t.Fatalf("rejected with no head: %s", err)
+	}
+}
+
+func TestStaleBlockRejectedOverRpc(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1", keyAddr(t, testKey))
+	p.StaleBlockPolicy = "reject"
+	atomic.StoreUint64(&p.head, 0x10)
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("got %+v, want invalid params", resp.Error)
+	}
+}
+
+func TestInvertedWindowRejectedOverRpc(t *testing.T) {
+	var forwarded int
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarded++
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.CheckTimestampWindow = true
+	params := `[{"txs":["0x01"],"blockNumber":"0x10","minTimestamp":9,"maxTimestamp":5}]`
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", params, testKey))
+	if resp.Error == nil || resp.Error.Code != -32602 || !strings.Contains(resp.Error.Message, "minTimestamp") {
+		t.Fatalf("got %+v, want invalid params", resp.Error)
+	}
+
+	// left to the upstream with the check off, the default
+	p.CheckTimestampWindow = false
+	resp = decodeResp(t, postRpc(t, p, "eth_sendBundle", params, testKey))
+	if resp.Error != nil || forwarded != 1 {
+		t.Fatalf("got %+v, forwarded %d", resp.Error, forwarded)
+	}
+}
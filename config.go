@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Validate checks the proxy settings and the listen address up front,
+// reporting every problem found in a single error
+func (p *Proxy) Validate(listenAddr string) error {
+	var problems []string
+
+	if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("listenAddr %q: %s", listenAddr, err))
+	}
+
+	if p.AdminAddr != "" {
+		if _, _, err := net.SplitHostPort(p.AdminAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("adminAddr %q: %s", p.AdminAddr, err))
+		} else if p.AdminAddr == listenAddr {
+			problems = append(problems, "adminAddr: must differ from listenAddr")
+		}
+	}
+
+	if p.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(p.MetricsAddr); err != nil {
+			problems = append(problems, fmt.Sprintf("metricsAddr %q: %s", p.MetricsAddr, err))
+		} else if p.MetricsAddr == listenAddr || p.MetricsAddr == p.AdminAddr {
+			problems = append(problems, "metricsAddr: must differ from listenAddr and adminAddr")
+		}
+	}
+
+	if u, err := url.Parse(p.RpcAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("rpcAddr %q: %s", p.RpcAddr, err))
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("rpcAddr %q: expected an http(s) URL", p.RpcAddr))
+	}
+
+	if len(p.SubmissionPaths) == 0 {
+		problems = append(problems, "submissionPaths: must not be empty")
+	}
+	for _, path := range p.SubmissionPaths {
+		if !strings.HasPrefix(path, "/") {
+			problems = append(problems, fmt.Sprintf("submissionPaths %q: must start with /", path))
+		} else if path == "/ready" {
+			problems = append(problems, "submissionPaths: /ready is the readiness endpoint")
+		}
+	}
+
+	for method, upstream := range p.MethodRoutes {
+		if u, err := url.Parse(upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("methodRoutes %s=%q: expected an http(s) URL", method, upstream))
+		}
+	}
+
+	if p.MirrorAddr != "" {
+		if u, err := url.Parse(p.MirrorAddr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("mirrorAddr %q: expected an http(s) URL", p.MirrorAddr))
+		}
+	}
+
+	for idx, bucket := range p.LatencyBuckets {
+		if bucket <= 0 || (idx > 0 && bucket <= p.LatencyBuckets[idx-1]) {
+			problems = append(problems, "latencyBuckets: must be positive and ascending")
+			break
+		}
+	}
+
+	if p.MaxUpstreamBodyBytes < 0 {
+		problems = append(problems, "maxUpstreamBodyBytes: must not be negative")
+	}
+
+	if p.SigningKey != nil && p.SignatureHeader == "" {
+		problems = append(problems, "signatureHeader: must be set when signing forwarded requests")
+	}
+
+	if p.SignReceipts && p.SigningKey == nil {
+		problems = append(problems, "signReceipts: needs signingKeyPath")
+	}
+
+	if p.ValidatorAddress != "" {
+		if len(p.ValidatorAddress) != 42 || !strings.HasPrefix(p.ValidatorAddress, "0x") {
+			problems = append(problems, fmt.Sprintf("validatorAddress %q: expected a 0x prefixed address", p.ValidatorAddress))
+		}
+		if p.ValidatorSignatureHeader == "" {
+			problems = append(problems, "validatorSignatureHeader: must be set when verifying validator acknowledgments")
+		}
+	}
+
+	if p.WhitelistURL != "" {
+		if u, err := url.Parse(p.WhitelistURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("whitelistURL %q: expected an http(s) URL", p.WhitelistURL))
+		}
+		if len(p.WhitelistSigner) != 42 || !strings.HasPrefix(p.WhitelistSigner, "0x") {
+			problems = append(problems, fmt.Sprintf("whitelistSigner %q: expected a 0x prefixed address", p.WhitelistSigner))
+		}
+	}
+
+	if !strings.HasPrefix(p.SubgraphPath, "/") {
+		problems = append(problems, fmt.Sprintf("subgraphPath %q: must start with /", p.SubgraphPath))
+	}
+
+	if p.MaxWhitelistShrink < 0 || p.MaxWhitelistShrink > 100 {
+		problems = append(problems, "maxWhitelistShrink: must be a percentage between 0 and 100")
+	}
+
+	if p.SignatureMode != "off" && p.SignatureMode != "optional" && p.SignatureMode != "required" {
+		problems = append(problems, fmt.Sprintf("signatureMode %q: must be off, optional or required", p.SignatureMode))
+	}
+
+	if p.AddressFormat != "lowercase" && p.AddressFormat != "checksum" {
+		problems = append(problems, fmt.Sprintf("addressFormat %q: must be lowercase or checksum", p.AddressFormat))
+	}
+
+	if p.WhitelistRefreshInterval <= 0 {
+		problems = append(problems, "whitelistRefreshInterval: must be positive")
+	}
+
+	if p.WhitelistRetryDelay <= 0 {
+		problems = append(problems, "whitelistRetryDelay: must be positive")
+	}
+
+	if p.DrainFile != "" && p.DrainFileInterval <= 0 {
+		problems = append(problems, "drainFileInterval: must be positive")
+	}
+
+	if p.ListenBacklog < 0 {
+		problems = append(problems, "listenBacklog: must not be negative")
+	}
+
+	if p.ConnRatePerIP < 0 {
+		problems = append(problems, "connRatePerIP: must not be negative")
+	}
+
+	if p.ConnRatePerIP > 0 && p.ConnBurstPerIP < 1 {
+		problems = append(problems, "connBurstPerIP: must be at least 1 when connRatePerIP is set")
+	}
+
+	if p.IdempotencyTTL < 0 {
+		problems = append(problems, "idempotencyTTL: must not be negative")
+	}
+
+	if p.IdempotencyTTL > 0 && p.IdempotencyCacheSize <= 0 {
+		problems = append(problems, "idempotencyCacheSize: must be positive when idempotencyTTL is set")
+	}
+
+	if p.HeadPollInterval < 0 {
+		problems = append(problems, "headPollInterval: must not be negative")
+	}
+
+	if p.MaxSignatureAge > 0 && p.HeadPollInterval == 0 {
+		problems = append(problems, "maxSignatureAge: needs headPollInterval to track the head")
+	}
+
+	if p.StrictBlockTarget && p.HeadPollInterval == 0 {
+		problems = append(problems, "strictBlockTarget: needs headPollInterval to track the head")
+	}
+
+	if p.StaleBlockPolicy != "" && p.StaleBlockPolicy != "reject" && p.StaleBlockPolicy != "retarget" {
+		problems = append(problems, fmt.Sprintf("staleBlockPolicy %q: must be reject, retarget or empty", p.StaleBlockPolicy))
+	}
+
+	if p.StaleBlockPolicy != "" && p.HeadPollInterval == 0 {
+		problems = append(problems, "staleBlockPolicy: needs headPollInterval to track the head")
+	}
+
+	if p.MaxClockDrift < 0 {
+		problems = append(problems, "maxClockDrift: must not be negative")
+	}
+
+	if p.MaxClockDrift > 0 && p.HeadPollInterval == 0 {
+		problems = append(problems, "maxClockDrift: needs headPollInterval to poll blocks")
+	}
+
+	if p.MaxQueuedPerAddress < 0 {
+		problems = append(problems, "maxQueuedPerAddress: must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+// ConfigSnapshot is the effective configuration as exported over admin,
+// with secrets redacted
+type ConfigSnapshot struct {
+	ListenAddr               string            `json:"listenAddr"`
+	RpcAddr                  string            `json:"rpcAddr"`
+	SubmissionPaths          []string          `json:"submissionPaths"`
+	MethodRoutes             map[string]string `json:"methodRoutes"`
+	PassthroughAll           bool              `json:"passthroughAll"`
+	MirrorAddr               string            `json:"mirrorAddr"`
+	LatencyBuckets           []string          `json:"latencyBuckets"`
+	MaxUpstreamBodyBytes     int               `json:"maxUpstreamBodyBytes"`
+	SigningKey               string            `json:"signingKey"`
+	SignatureHeader          string            `json:"signatureHeader"`
+	SignReceipts             bool              `json:"signReceipts"`
+	ValidatorAddress         string            `json:"validatorAddress"`
+	ValidatorSignatureHeader string            `json:"validatorSignatureHeader"`
+	SubgraphPath             string            `json:"subgraphPath"`
+	WhitelistURL             string            `json:"whitelistURL"`
+	WhitelistSigner          string            `json:"whitelistSigner"`
+	MaxWhitelistShrink       float64           `json:"maxWhitelistShrink"`
+	WhitelistRefreshInterval string            `json:"whitelistRefreshInterval"`
+	WhitelistRetryDelay      string            `json:"whitelistRetryDelay"`
+	StrictRequestBody        bool              `json:"strictRequestBody"`
+	SignatureMode            string            `json:"signatureMode"`
+	AddressFormat            string            `json:"addressFormat"`
+	EchoSender               bool              `json:"echoSender"`
+	DebugRecoverSigner       bool              `json:"debugRecoverSigner"`
+	RequireTimestamps        bool              `json:"requireTimestamps"`
+	CheckTimestampWindow     bool              `json:"checkTimestampWindow"`
+	MaxSignatureAge          uint64            `json:"maxSignatureAge"`
+	StrictBlockTarget        bool              `json:"strictBlockTarget"`
+	StaleBlockPolicy         string            `json:"staleBlockPolicy"`
+	MaxClockDrift            string            `json:"maxClockDrift"`
+	HeadPollInterval         string            `json:"headPollInterval"`
+	MaxQueuedPerAddress      int64             `json:"maxQueuedPerAddress"`
+	CapLogInterval           string            `json:"capLogInterval"`
+	ReusePort                bool              `json:"reusePort"`
+	ListenBacklog            int               `json:"listenBacklog"`
+	ConnRatePerIP            float64           `json:"connRatePerIP"`
+	ConnBurstPerIP           int               `json:"connBurstPerIP"`
+	IdempotencyTTL           string            `json:"idempotencyTTL"`
+	IdempotencyCacheSize     int               `json:"idempotencyCacheSize"`
+	AdminAddr                string            `json:"adminAddr"`
+	MetricsAddr              string            `json:"metricsAddr"`
+	AdminToken               string            `json:"adminToken"`
+	DrainFile                string            `json:"drainFile"`
+	DrainFileInterval        string            `json:"drainFileInterval"`
+	StatsPersistPath         string            `json:"statsPersistPath"`
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "<redacted>"
+}
+
+func (p *Proxy) configSnapshot() *ConfigSnapshot {
+	latencyBuckets := make([]string, 0, len(p.LatencyBuckets))
+	for _, bucket := range p.LatencyBuckets {
+		latencyBuckets = append(latencyBuckets, bucket.String())
+	}
+
+	return &ConfigSnapshot{
+		ListenAddr:               p.listenAddr,
+		RpcAddr:                  p.RpcAddr,
+		SubmissionPaths:          p.SubmissionPaths,
+		MethodRoutes:             p.MethodRoutes,
+		PassthroughAll:           p.PassthroughAll,
+		MirrorAddr:               p.MirrorAddr,
+		LatencyBuckets:           latencyBuckets,
+		MaxUpstreamBodyBytes:     p.MaxUpstreamBodyBytes,
+		SigningKey:               redact(string(p.SigningKey)),
+		SignatureHeader:          p.SignatureHeader,
+		SignReceipts:             p.SignReceipts,
+		ValidatorAddress:         p.ValidatorAddress,
+		ValidatorSignatureHeader: p.ValidatorSignatureHeader,
+		SubgraphPath:             p.SubgraphPath,
+		WhitelistURL:             p.WhitelistURL,
+		WhitelistSigner:          p.WhitelistSigner,
+		MaxWhitelistShrink:       p.MaxWhitelistShrink,
+		WhitelistRefreshInterval: p.WhitelistRefreshInterval.String(),
+		WhitelistRetryDelay:      p.WhitelistRetryDelay.String(),
+		StrictRequestBody:        p.StrictRequestBody,
+		SignatureMode:            p.SignatureMode,
+		AddressFormat:            p.AddressFormat,
+		EchoSender:               p.EchoSender,
+		DebugRecoverSigner:       p.DebugRecoverSigner,
+		RequireTimestamps:        p.RequireTimestamps,
+		CheckTimestampWindow:     p.CheckTimestampWindow,
+		MaxSignatureAge:          p.MaxSignatureAge,
+		StrictBlockTarget:        p.StrictBlockTarget,
+		StaleBlockPolicy:         p.StaleBlockPolicy,
+		MaxClockDrift:            p.MaxClockDrift.String(),
+		HeadPollInterval:         p.HeadPollInterval.String(),
+		MaxQueuedPerAddress:      p.MaxQueuedPerAddress,
+		CapLogInterval:           p.CapLogInterval.String(),
+		ReusePort:                p.ReusePort,
+		ListenBacklog:            p.ListenBacklog,
+		ConnRatePerIP:            p.ConnRatePerIP,
+		ConnBurstPerIP:           p.ConnBurstPerIP,
+		IdempotencyTTL:           p.IdempotencyTTL.String(),
+		IdempotencyCacheSize:     p.IdempotencyCacheSize,
+		AdminAddr:                p.AdminAddr,
+		MetricsAddr:              p.MetricsAddr,
+		AdminToken:               redact(p.AdminToken),
+		DrainFile:                p.DrainFile,
+		DrainFileInterval:        p.DrainFileInterval.String(),
+		StatsPersistPath:         p.StatsPersistPath,
+	}
+}
+
+func (p *Proxy) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(404)
+		return
+	}
+
+	writeJSON(w, 200, p.configSnapshot())
+}
+
+// parseLatencyBuckets parses a comma separated list of durations
+func parseLatencyBuckets(buckets string) ([]time.Duration, error) {
+	var parsed []time.Duration
+	if buckets == "" {
+		return parsed, nil
+	}
+
+	for _, bucket := range strings.Split(buckets, ",") {
+		d, err := time.ParseDuration(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("latencyBuckets %q: %s", bucket, err)
+		}
+		parsed = append(parsed, d)
+	}
+
+	return parsed, nil
+}
+
+// parseMethodRoutes parses a comma separated list of method=upstream pairs
+func parseMethodRoutes(routes string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	if routes == "" {
+		return parsed, nil
+	}
+
+	for _, route := range strings.Split(routes, ",") {
+		parts := strings.SplitN(route, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("methodRoutes %q: expected method=upstream", route)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateDefaults(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8545")
+	err := p.Validate("0.0.0.0:18545")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	p := newTestProxy("localhost:8545")
+	p.AdminAddr = "0.0.0.0:18545"
+	p.SignatureMode = "sometimes"
+	p.MaxWhitelistShrink = 150
+	p.SignReceipts = true
+	p.StrictBlockTarget = true
+
+	err := p.Validate("0.0.0.0:18545")
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		"adminAddr: must differ from listenAddr",
+		`rpcAddr "localhost:8545"`,
+		`signatureMode "sometimes"`,
+		"maxWhitelistShrink",
+		"signReceipts: needs signingKeyPath",
+		"strictBlockTarget: needs headPollInterval",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%s", want, err)
+		}
+	}
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8545")
+	err := p.Validate("18545")
+	if err == nil || !strings.Contains(err.Error(), "listenAddr") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestConfigSnapshotRedactsSecrets(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:8545")
+	p.AdminToken = "secret"
+	p.SigningKey = otherKey
+	p.listenAddr = "0.0.0.0:18545"
+
+	w := adminRequest(p, "GET", "/admin/config", "secret")
+	if w.Code != 200 {
+		t.Fatalf("status %d", w.Code)
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "secret") || strings.Contains(body, string(otherKey)) {
+		t.Fatalf("secret in %s", body)
+	}
+
+	config := &ConfigSnapshot{}
+	err := json.Unmarshal(w.Body.Bytes(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.AdminToken != "<redacted>" || config.SigningKey != "<redacted>" {
+		t.Fatalf("got adminToken %q signingKey %q", config.AdminToken, config.SigningKey)
+	}
+	if config.ListenAddr != "0.0.0.0:18545" || config.RpcAddr != p.RpcAddr || config.WhitelistRefreshInterval != "1m0s" {
+		t.Fatalf("got %+v", config)
+	}
+
+	p.SigningKey = nil
+	if config := p.configSnapshot(); config.SigningKey != "" {
+		t.Fatalf("unset signing key shown as %q", config.SigningKey)
+	}
+}
+
+func TestParseMethodRoutes(t *testing.T) {
+	routes, err := parseMethodRoutes("eth_sendBundle=http://a:1,eth_call=http://b:2/rpc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes["eth_sendBundle"] != "http://a:1" || routes["eth_call"] != "http://b:2/rpc?x=1" {
+		t.Fatalf("got %v", routes)
+	}
+
+	for _, bad := range []string{"eth_call", "=http://a:1", "eth_call=http://a:1,"} {
+		if _, err := parseMethodRoutes(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+
+	p := newTestProxy("http://127.0.0.1:8545")
+	p.MethodRoutes = map[string]string{"eth_call": "b:2"}
+	err = p.Validate("0.0.0.0:18545")
+	if err == nil || !strings.Contains(err.Error(), "methodRoutes eth_call") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type connBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// connLimitListener closes connections from IPs opening them faster than
+// rate per second, allowing bursts of up to burst. It works below HTTP,
+// so it only sees the TCP peer and never a forwarded client IP.
+type connLimitListener struct {
+	net.Listener
+	rate  float64
+	burst float64
+
+	lock      sync.Mutex
+	buckets   map[string]*connBucket
+	lastSweep time.Time
+}
+
+func newConnLimitListener(l net.Listener, rate float64, burst int) *connLimitListener {
+	return &connLimitListener{
+		Listener:  l,
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*connBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil || l.allow(ip) {
+			return conn, nil
+		}
+
+		connRejections.Add(1)
+		conn.Close()
+	}
+}
+
+func (l *connLimitListener) allow(ip string) bool {
+	now := time.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Drop buckets that have refilled, they carry no state
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &connBucket{l.burst, now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
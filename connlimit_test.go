@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimitAllowsBurstPerIP(t *testing.T) {
+	l := newConnLimitListener(nil, 0.001, 3)
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("10.0.0.1") {
+			t.Fatalf("connection %d within the burst refused", i+1)
+		}
+	}
+	if l.allow("10.0.0.1") {
+		t.Fatal("connection past the burst allowed")
+	}
+	if !l.allow("10.0.0.2") {
+		t.Fatal("another IP limited by the first")
+	}
+
+	// refilled after a second at 1000 per second
+	l.rate = 1000
+	l.buckets["10.0.0.1"].last = time.Now().Add(-time.Second)
+	if !l.allow("10.0.0.1") {
+		t.Fatal("refilled bucket still refusing")
+	}
+}
+
+func TestConnLimitListenerClosesExcessConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newConnLimitListener(inner, 0.001, 1)
+	defer l.Close()
+
+	before := intVar("connRejections")
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	conn := <-accepted
+	defer conn.Close()
+
+	second, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read from a connection past the limit")
+	}
+	if intVar("connRejections") != before+1 {
+		t.Fatal("rejection not counted")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("connection past the limit accepted")
+	default:
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// parseBlockNumber accepts a block number as a hex string or a JSON number
+func parseBlockNumber(raw json.RawMessage) (uint64, error) {
+	var hexStr string
+	if json.Unmarshal(raw, &hexStr) == nil {
+		if len(hexStr) < 3 || hexStr[:2] != "0x" {
+			return 0, fmt.Errorf("block number %q is not 0x prefixed hex", hexStr)
+		}
+		return strconv.ParseUint(hexStr[2:], 16, 64)
+	}
+
+	var num uint64
+	err := json.Unmarshal(raw, &num)
+	if err != nil {
+		return 0, fmt.Errorf("block number %s is not hex or a number", raw)
+	}
+	return num, nil
+}
+
+// Head returns the latest upstream block number, 0 if not known yet
+func (p *Proxy) Head() uint64 {
+	return atomic.LoadUint64(&p.head)
+}
+
+func (p *Proxy) fetchHead(ctx context.Context) (uint64, error) {
+	resp := p.makeRpcCall(ctx, &RpcReq{
+		"2.0",
+		"eth_blockNumber",
+		json.RawMessage("[]"),
+		json.RawMessage("1"),
+	}, p.RpcAddr)
+	if resp.Error != nil {
+		return 0, fmt.Errorf("%s", resp.Error.Message)
+	}
+
+	resultBytes, _ := json.Marshal(resp.Result)
+	return parseBlockNumber(resultBytes)
+}
+
+// checkClockDrift compares the local clock to the latest block's
+// timestamp, warning when they are further apart than MaxClockDrift
+func (p *Proxy) checkClockDrift(ctx context.Context) error {
+	resp := p.makeRpcCall(ctx, &RpcReq{
+		"2.0",
+		"eth_getBlockByNumber",
+		json.RawMessage(`["latest", false]`),
+		json.RawMessage("1"),
+	}, p.RpcAddr)
+	if resp.Error != nil {
+		return fmt.Errorf("%s", resp.Error.Message)
+	}
+
+	block, ok := resp.Result.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("latest block missing")
+	}
+	timestampBytes, _ := json.Marshal(block["timestamp"])
+	// same encoding as block numbers
+	timestamp, err := parseBlockNumber(timestampBytes)
+	if err != nil {
+		return fmt.Errorf("block timestamp: %s", err)
+	}
+
+	drift := time.Since(time.Unix(int64(timestamp), 0))
+	clockDrift.Set(int64(drift / time.Millisecond))
+	if drift > p.MaxClockDrift || drift < -p.MaxClockDrift {
+		clockDriftWarnings.Add(1)
+		fmt.Println("WARN: local clock is", drift, "off the latest block timestamp, over the", p.MaxClockDrift, "limit")
+	}
+
+	return nil
+}
+
+// pollHead keeps Head up to date every HeadPollInterval
+func (p *Proxy) pollHead() {
+	ctx, cancel := p.stopContext()
+	defer cancel()
+
+	for {
+		head, err := p.fetchHead(ctx)
+		if err != nil {
+			fmt.Println("head fetch err", err)
+		} else {
+			// A lower head means the upstream reorged, admission checks
+			// follow the new canonical chain from here on
+			previous := atomic.SwapUint64(&p.head, head)
+			if head < previous {
+				headRegressions.Add(1)
+				fmt.Println("WARN: head went back from", previous, "to", head, "likely a reorg")
+			}
+		}
+
+		if p.MaxClockDrift > 0 {
+			err = p.checkClockDrift(ctx)
+			if err != nil {
+				fmt.Println("clock drift check err", err)
+			}
+		}
+
+		if !p.sleep(p.HeadPollInterval) {
+			return
+		}
+	}
+}
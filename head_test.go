@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newChain serves eth_blockNumber and a latest block with timestamp
+func newChain(t *testing.T, head func() uint64, timestamp func() int64) *Proxy {
+	t.Helper()
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		if req.Method == "eth_getBlockByNumber" {
+			return map[string]interface{}{"timestamp": fmt.Sprintf("0x%x", timestamp())}
+		}
+		return fmt.Sprintf("0x%x", head())
+	})
+	return newTestProxy(upstream.URL)
+}
+
+func TestClockDrift(t *testing.T) {
+	blockTime := time.Now().Unix()
+	p := newChain(t, func() uint64 { return 1 }, func() int64 { return blockTime })
+	p.MaxClockDrift = time.Minute
+
+	warnings := intVar("clockDriftWarnings")
+	err := p.checkClockDrift(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift := intVar("clockDriftMs"); drift < 0 || drift > 5000 {
+		t.Fatalf("drift %dms against a current block", drift)
+	}
+	if intVar("clockDriftWarnings") != warnings {
+		t.Fatal("warned within maxClockDrift")
+	}
+
+	// the local clock two minutes ahead, and then behind
+	for _, offset := range []int64{-120, 120} {
+		blockTime = time.Now().Unix() + offset
+		err = p.checkClockDrift(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if drift := intVar("clockDriftMs"); drift/1000 < -offset-5 || drift/1000 > -offset+5 {
+			t.Fatalf("drift %dms against a block %ds off", drift, offset)
+		}
+		warnings++
+		if intVar("clockDriftWarnings") != warnings {
+			t.Fatalf("no warning for a block %ds off", offset)
+		}
+	}
+}
+
+func TestClockDriftBadBlock(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"timestamp": "soon"}
+	})
+	p := newTestProxy(upstream.URL)
+	p.MaxClockDrift = time.Minute
+
+	if err := p.checkClockDrift(context.Background()); err == nil {
+		t.Fatal("accepted a malformed timestamp")
+	}
+}
+
+func TestHeadRegression(t *testing.T) {
+	heads := []uint64{10, 11, 9, 9, 12}
+	var polls int32
+	polled := make(chan struct{}, 64)
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		// the last head repeats once the sequence is through
+		n := int(atomic.AddInt32(&polls, 1))
+		if n > len(heads) {
+			n = len(heads)
+		}
+		defer func() {
+			select {
+			case polled <- struct{}{}:
+			default:
+			}
+		}()
+		return fmt.Sprintf("0x%x", heads[n-1])
+	})
+	p := newTestProxy(upstream.URL)
+	p.HeadPollInterval = time.Millisecond
+
+	regressions := intVar("headRegressions")
+	p.spawn(p.pollHead)
+	// the head is stored after the poll is answered, so wait for one more
+	for i := 0; i <= len(heads); i++ {
+		<-polled
+	}
+	p.Stop()
+
+	if p.Head() != 12 {
+		t.Fatalf("head %d", p.Head())
+	}
+	if intVar("headRegressions") != regressions+1 {
+		t.Fatalf("%d regressions counted", intVar("headRegressions")-regressions)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+type ReadyStatus struct {
+	Ready    bool  `json:"ready"`
+	Draining bool  `json:"draining"`
+	Inflight int64 `json:"inflight"`
+	// Requests a single signer may have pending, 0 when unlimited
+	MaxQueuedPerAddress int64 `json:"maxQueuedPerAddress"`
+	// Bundles pending against the per-signer caps, and how many the
+	// whitelisted signers could have pending at once, 0 when unlimited
+	Pending  int64 `json:"pending"`
+	Capacity int64 `json:"capacity"`
+	// Pending as a percentage of Capacity, -1 when unlimited
+	Utilization   float64 `json:"utilization"`
+	WhitelistSize int     `json:"whitelistSize"`
+	// Seconds since the whitelist was last replaced, -1 if never loaded
+	WhitelistAge float64 `json:"whitelistAge"`
+	Head         uint64  `json:"head"`
+}
+
+func (p *Proxy) readyStatus() *ReadyStatus {
+	whitelist := (*map[string]struct{})(atomic.LoadPointer(&p.Whitelist))
+	status := &ReadyStatus{
+		Draining:            p.isDraining(),
+		Inflight:            atomic.LoadInt64(&p.inflight),
+		MaxQueuedPerAddress: p.MaxQueuedPerAddress,
+		WhitelistSize:       len(*whitelist),
+		WhitelistAge:        -1,
+		Head:                p.Head(),
+		Utilization:         -1,
+	}
+
+	// Pending counters are only kept while a cap is set
+	p.pending.Range(func(key, value interface{}) bool {
+		if count := atomic.LoadInt64(value.(*int64)); count > 0 {
+			status.Pending += count
+		}
+		return true
+	})
+	status.Capacity = p.MaxQueuedPerAddress * int64(status.WhitelistSize)
+	if status.Capacity > 0 {
+		status.Utilization = float64(status.Pending) * 100 / float64(status.Capacity)
+	}
+
+	updated := atomic.LoadInt64(&p.whitelistUpdated)
+	if updated != 0 {
+		status.WhitelistAge = time.Since(time.Unix(0, updated)).Seconds()
+	}
+
+	// Nothing can be accepted before the whitelist loads
+	status.Ready = !status.Draining && status.WhitelistSize > 0
+	return status
+}
+
+// handleReady answers 200 when bundles can be accepted and 503 otherwise,
+// with the details in the body either way
+func (p *Proxy) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(404)
+		return
+	}
+
+	status := p.readyStatus()
+	if status.Ready {
+		writeJSON(w, 200, status)
+	} else {
+		writeJSON(w, 503, status)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func getReady(t *testing.T, p *Proxy) (int, *ReadyStatus) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	p.handleReady(w, httptest.NewRequest("GET", "/ready", nil))
+	status := &ReadyStatus{}
+	err := json.Unmarshal(w.Body.Bytes(), status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, status
+}
+
+func TestReady(t *testing.T) {
+	p := newTestProxy("")
+	p.MaxQueuedPerAddress = 4
+
+	code, status := getReady(t, p)
+	if code != 503 || status.Ready || status.WhitelistSize != 0 || status.WhitelistAge != -1 || status.Utilization != -1 {
+		t.Fatalf("before the whitelist loaded: %d %+v", code, status)
+	}
+
+	p.updateWhitelist([]string{"0x1", "0x2"}, 0)
+	code, status = getReady(t, p)
+	if code != 200 || !status.Ready || status.WhitelistSize != 2 || status.WhitelistAge < 0 || status.MaxQueuedPerAddress != 4 {
+		t.Fatalf("with a whitelist: %d %+v", code, status)
+	}
+
+	// a partly full proxy, 2 of the 8 slots two signers may hold
+	if !p.acquirePending("0x1") || !p.acquirePending("0x1") {
+		t.Fatal("acquire under the cap failed")
+	}
+	code, status = getReady(t, p)
+	if code != 200 || status.Pending != 2 || status.Capacity != 8 || status.Utilization != 25 {
+		t.Fatalf("partly full: %d %+v", code, status)
+	}
+	p.releasePending("0x1")
+	p.releasePending("0x1")
+
+	p.drainedByAdmin = 1
+	code, status = getReady(t, p)
+	if code != 503 || status.Ready || !status.Draining {
+		t.Fatalf("draining: %d %+v", code, status)
+	}
+}
+
+func TestReadyOnlyAnswersGet(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestProxy("").handleReady(w, httptest.NewRequest("POST", "/ready", nil))
+	if w.Code != 404 {
+		t.Fatalf("status %d", w.Code)
+	}
+}
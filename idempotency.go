@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+type idempotencyEntry struct {
+	// closed once resp is set
+	done chan struct{}
+	// marshalled first response, so repeats never share its result with
+	// the request that is still filling it in
+	resp    []byte
+	expires time.Time
+}
+
+type idempotencyCache struct {
+	lock    sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// idempotent runs send once per key within IdempotencyTTL. Repeats get
+// the first response, waiting for it if the first is still in flight,
+// and replayed reports whether resp is such a copy. Error responses are
+// not remembered so that retries go through.
+func (p *Proxy) idempotent(ctx context.Context, key string, req *RpcReq, send func() *RpcResp) (resp *RpcResp, replayed bool) {
+	c := &p.idempotency
+	now := time.Now()
+
+	c.lock.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotencyEntry)
+	}
+	entry, ok := c.entries[key]
+	if ok && now.After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if ok {
+		c.lock.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return &RpcResp{
+				"2.0",
+				nil,
+				&RpcErr{
+					-32603,
+					"Request cancelled",
+					nil,
+				},
+				req.Id,
+			}, false
+		}
+		if entry.resp == nil {
+			// first attempt failed and was forgotten, go again
+			return send(), false
+		}
+
+		resp = &RpcResp{}
+		if json.Unmarshal(entry.resp, resp) != nil {
+			return send(), false
+		}
+		resp.Id = req.Id
+		return resp, true
+	}
+
+	if len(c.entries) >= p.IdempotencyCacheSize {
+		c.evictExpired(now)
+	}
+	if len(c.entries) >= p.IdempotencyCacheSize {
+		// full of live keys, forward without remembering
+		c.lock.Unlock()
+		return send(), false
+	}
+
+	entry = &idempotencyEntry{
+		done:    make(chan struct{}),
+		expires: now.Add(p.IdempotencyTTL),
+	}
+	c.entries[key] = entry
+	c.lock.Unlock()
+
+	resp = send()
+
+	var respBytes []byte
+	if resp.Error == nil {
+		respBytes, _ = json.Marshal(resp)
+	}
+
+	c.lock.Lock()
+	if respBytes != nil {
+		entry.resp = respBytes
+	} else if c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+	c.lock.Unlock()
+	close(entry.done)
+
+	return resp, false
+}
+
+// evictExpired drops expired entries, must hold lock
+func (c *idempotencyCache) evictExpired(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeyForwardsOnce(t *testing.T) {
+	var calls int32
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		atomic.AddInt32(&calls, 1)
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.IdempotencyTTL = time.Minute
+	p.EchoSender = true
+
+	first := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey, "Idempotency-Key", "a"))
+	second := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey, "Idempotency-Key", "a"))
+
+	if calls != 1 {
+		t.Fatalf("upstream called %d times, want 1", calls)
+	}
+	firstBytes, _ := json.Marshal(first.Result)
+	secondBytes, _ := json.Marshal(second.Result)
+	if string(firstBytes) != string(secondBytes) {
+		t.Fatalf("repeat got %s, first got %s", secondBytes, firstBytes)
+	}
+
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey, "Idempotency-Key", "b"))
+	if calls != 2 {
+		t.Fatalf("upstream called %d times for a new key, want 2", calls)
+	}
+}
+
+func TestIdempotencyCacheIsNotSharedWithCallers(t *testing.T) {
+	p := newTestProxy("")
+	p.IdempotencyTTL = time.Minute
+	p.IdempotencyCacheSize = 10
+	req := &RpcReq{"2.0", "eth_sendBundle", nil, json.RawMessage("1")}
+
+	first, _ := p.idempotent(context.Background(), "k", req, func() *RpcResp {
+		return &RpcResp{"2.0", map[string]interface{}{"bundleHash": "0x01"}, nil, req.Id}
+	})
+	// what handleRpc does with a response after idempotent returns
+	first.Result.(map[string]interface{})["sender"] = "0xabc"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, replayed := p.idempotent(context.Background(), "k", req, func() *RpcResp {
+				t.Error("forwarded a cached key")
+				return nil
+			})
+			if !replayed {
+				t.Error("cached response not reported as replayed")
+			}
+			result := resp.Result.(map[string]interface{})
+			if _, ok := result["sender"]; ok {
+				t.Error("repeat saw a field added to the first response")
+			}
+			resp.Result = withField(resp.Result, "receipt", "r")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIdempotencyForgetsErrors(t *testing.T) {
+	p := newTestProxy("")
+	p.IdempotencyTTL = time.Minute
+	p.IdempotencyCacheSize = 10
+	req := &RpcReq{"2.0", "eth_sendBundle", nil, json.RawMessage("1")}
+
+	p.idempotent(context.Background(), "k", req, func() *RpcResp {
+		return &RpcResp{"2.0", nil, &RpcErr{-32003, "Upstream unavailable", nil}, req.Id}
+	})
+	resp, replayed := p.idempotent(context.Background(), "k", req, func() *RpcResp {
+		return &RpcResp{"2.0", "ok", nil, req.Id}
+	})
+	if resp.Result != "ok" || replayed {
+		t.Fatalf("got %v, want a fresh forward after an error", resp.Result)
+	}
+}
+
+func TestIdempotentReplaysAreNotCountedOrReceiptedAgain(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.IdempotencyTTL = time.Minute
+	p.SigningKey = otherKey
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+	p.SignReceipts = true
+
+	before := p.stats()
+	first := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey, "Idempotency-Key", "a"))
+	second := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey, "Idempotency-Key", "a"))
+	after := p.stats()
+
+	if after.Accepted-before.Accepted != 1 || after.Dispatched-before.Dispatched != 1 || after.Replayed-before.Replayed != 1 {
+		t.Fatalf("before %+v after %+v", before, after)
+	}
+	firstReceipt, _ := json.Marshal(first.Result.(map[string]interface{})["receipt"])
+	secondReceipt, _ := json.Marshal(second.Result.(map[string]interface{})["receipt"])
+	if string(firstReceipt) != string(secondReceipt) {
+		t.Fatalf("replay got receipt %s, first got %s", secondReceipt, firstReceipt)
+	}
+}
+
+func TestIdempotencyKeysNeedASender(t *testing.T) {
+	var calls int32
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		atomic.AddInt32(&calls, 1)
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL)
+	p.IdempotencyTTL = time.Minute
+	p.SignatureMode = "optional"
+
+	// unsigned requests from different clients must not share a key
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, nil, "Idempotency-Key", "a"))
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, nil, "Idempotency-Key", "a"))
+	if calls != 2 {
+		t.Fatalf("upstream called %d times, want every unsigned request forwarded", calls)
+	}
+}
+
+func TestIdempotentWaitEndsWithTheRequest(t *testing.T) {
+	p := newTestProxy("")
+	p.IdempotencyTTL = time.Minute
+	p.IdempotencyCacheSize = 10
+	req := &RpcReq{"2.0", "eth_sendBundle", nil, json.RawMessage("1")}
+
+	release := make(chan struct{})
+	sending := make(chan struct{})
+	go p.idempotent(context.Background(), "k", req, func() *RpcResp {
+		close(sending)
+		<-release
+		return &RpcResp{"2.0", "ok", nil, req.Id}
+	})
+	defer close(release)
+	<-sending
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *RpcResp)
+	go func() {
+		resp, _ := p.idempotent(ctx, "k", req, func() *RpcResp {
+			t.Error("forwarded a key that is in flight")
+			return nil
+		})
+		done <- resp
+	}()
+	cancel()
+
+	select {
+	case resp := <-done:
+		if resp.Error == nil {
+			t.Fatalf("got %+v, want an error for the cancelled request", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting on the first attempt after the request was cancelled")
+	}
+}
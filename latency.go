@@ -0,0 +1,117 @@
+package main
+
+import (
+	"expvar"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// latencyHistogram counts upstream response times per upstream in
+// cumulative buckets, Prometheus style
+type latencyHistogram struct {
+	lock sync.Mutex
+	// ascending upper bounds, nil when not recording
+	buckets []time.Duration
+	// upstream label -> observations at or under each bound, then the
+	// total
+	counts map[string][]int64
+	sums   map[string]time.Duration
+}
+
+// LatencySnapshot is one upstream's histogram as exported in expvar
+type LatencySnapshot struct {
+	// bucket upper bound -> observations at or under it, +Inf for all
+	Buckets map[string]int64 `json:"buckets"`
+	Count   int64            `json:"count"`
+	SumMs   float64          `json:"sumMs"`
+	// Estimated as the upper bound of the bucket holding the quantile,
+	// -1 if it falls past the last bound
+	P50Ms float64 `json:"p50Ms"`
+	P95Ms float64 `json:"p95Ms"`
+	P99Ms float64 `json:"p99Ms"`
+}
+
+func init() {
+	expvar.Publish("deliveryLatency", expvar.Func(func() interface{} {
+		return deliveryLatency.snapshot()
+	}))
+}
+
+func (h *latencyHistogram) setBuckets(buckets []time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.buckets = buckets
+	h.counts = make(map[string][]int64)
+	h.sums = make(map[string]time.Duration)
+}
+
+// upstreamLabel keys an upstream by scheme and host only, since its
+// userinfo, path or query may carry credentials
+func upstreamLabel(upstream string) string {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (h *latencyHistogram) observe(upstream string, d time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.buckets == nil {
+		return
+	}
+
+	upstream = upstreamLabel(upstream)
+
+	counts, ok := h.counts[upstream]
+	if !ok {
+		counts = make([]int64, len(h.buckets)+1)
+		h.counts[upstream] = counts
+	}
+	for idx, bound := range h.buckets {
+		if d <= bound {
+			counts[idx]++
+		}
+	}
+	counts[len(h.buckets)]++
+	h.sums[upstream] += d
+}
+
+func (h *latencyHistogram) snapshot() map[string]*LatencySnapshot {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	snapshots := make(map[string]*LatencySnapshot)
+	for upstream, counts := range h.counts {
+		total := counts[len(h.buckets)]
+		snapshot := &LatencySnapshot{
+			Buckets: make(map[string]int64),
+			Count:   total,
+			SumMs:   float64(h.sums[upstream]) / float64(time.Millisecond),
+		}
+		for idx, bound := range h.buckets {
+			snapshot.Buckets[bound.String()] = counts[idx]
+		}
+		snapshot.Buckets["+Inf"] = total
+
+		quantile := func(q float64) float64 {
+			for idx, bound := range h.buckets {
+				if float64(counts[idx]) >= q*float64(total) {
+					return float64(bound) / float64(time.Millisecond)
+				}
+			}
+			return -1
+		}
+		snapshot.P50Ms = quantile(0.5)
+		snapshot.P95Ms = quantile(0.95)
+		snapshot.P99Ms = quantile(0.99)
+
+		snapshots[upstream] = snapshot
+	}
+
+	return snapshots
+}
@@ -0,0 +1,18 @@
+//go:build !(linux && (amd64 || arm64 || 386 || arm))
+// +build !linux !amd64,!arm64,!386,!arm
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("reusePort is only supported on linux amd64, arm64, 386 and arm")
+}
+
+func setBacklog(l net.Listener, backlog int) error {
+	return fmt.Errorf("listenBacklog is only supported on linux amd64, arm64, 386 and arm")
+}
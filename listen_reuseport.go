@@ -0,0 +1,50 @@
+//go:build linux && (amd64 || arm64 || 386 || arm)
+// +build linux
+// +build amd64 arm64 386 arm
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// SO_REUSEPORT on the architectures above, the syscall package does not
+// export it
+const soReusePort = 0xf
+
+// reusePortControl sets SO_REUSEPORT so another process can bind the same
+// port during a handover
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setBacklog re-issues listen on a listening socket, which on linux
+// replaces its backlog
+func setBacklog(l net.Listener, backlog int) error {
+	tcp, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+
+	c, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var listenErr error
+	err = c.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
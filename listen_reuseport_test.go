@@ -0,0 +1,71 @@
+//go:build linux && (amd64 || arm64 || 386 || arm)
+// +build linux
+// +build amd64 arm64 386 arm
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReusePortAllowsASecondListener(t *testing.T) {
+	p := newTestProxy("")
+	p.ReusePort = true
+
+	first, err := p.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// what the process taking over binds, for the admin and metrics
+	// listeners as much as the public one
+	second, err := p.listen(first.Addr().String())
+	if err != nil {
+		t.Fatalf("second listener on %s: %s", first.Addr(), err)
+	}
+	second.Close()
+}
+
+func TestWithoutReusePortTheAddressIsTaken(t *testing.T) {
+	p := newTestProxy("")
+
+	first, err := p.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := p.listen(first.Addr().String())
+	if err == nil {
+		second.Close()
+		t.Fatal("second listener bound without reusePort")
+	}
+}
+
+func TestSetBacklog(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	err = setBacklog(l, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// still listening after the backlog change
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// anything but a TCP listener is left alone
+	err = setBacklog(newConnLimitListener(l, 1, 1), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,17 +3,142 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 )
 
 func main() {
 	listenAddrPtr := flag.String("listenAddr", "127.0.0.1:18545", "listen address")
-	rpcAddrPtr := flag.String("rpcAddr", "127.0.0.1:8545", "rpc address")
+	rpcAddrPtr := flag.String("rpcAddr", "http://127.0.0.1:8545", "rpc address")
+	submissionPathsPtr := flag.String("submissionPaths", "/", "comma separated paths accepting JSON-RPC requests")
+	methodRoutesPtr := flag.String("methodRoutes", "", "comma separated method=upstream pairs, other methods go to rpcAddr")
+	passthroughAllPtr := flag.Bool("passthroughAll", false, "forward any other non bundle method from a whitelisted signer to the upstream")
+	latencyBucketsPtr := flag.String("latencyBuckets", "10ms,25ms,50ms,100ms,250ms,500ms,1s,2.5s,5s", "comma separated upper bounds of the per upstream delivery latency histogram, empty to not record it")
+	mirrorAddrPtr := flag.String("mirrorAddr", "", "sidecar to post a copy of each accepted bundle to, best effort, empty for none")
+	maxUpstreamBodyBytesPtr := flag.Int("maxUpstreamBodyBytes", 0, "max size of a request forwarded upstream, 0 for no limit")
+	signingKeyPathPtr := flag.String("signingKeyPath", "", "file with a hex private key to sign forwarded requests with, unsigned when empty")
+	signatureHeaderPtr := flag.String("signatureHeader", "X-Marlin-Proxy-Signature", "header carrying the proxy signature to the upstream")
+	validatorAddressPtr := flag.String("validatorAddress", "", "address that must sign bundle delivery responses, empty to trust the upstream")
+	validatorSignatureHeaderPtr := flag.String("validatorSignatureHeader", "X-Validator-Signature", "header carrying the validator signature on delivery responses")
+	signReceiptsPtr := flag.Bool("signReceipts", false, "add a receipt signed with the signing key to accepted bundle responses")
 	subgraphPathPtr := flag.String("subgraphPath", "/marlinprotocol/mev-bor", "subgraph path")
+	whitelistURLPtr := flag.String("whitelistURL", "", "signed whitelist file to fetch instead of the subgraph")
+	whitelistSignerPtr := flag.String("whitelistSigner", "", "address that must have signed the whitelistURL file")
+	maxWhitelistShrinkPtr := flag.Float64("maxWhitelistShrink", 50, "max percentage the whitelist may shrink by in one fetch, 100 to disable")
+	whitelistRefreshIntervalPtr := flag.Duration("whitelistRefreshInterval", 60*time.Second, "time between whitelist fetches")
+	whitelistRetryDelayPtr := flag.Duration("whitelistRetryDelay", 5*time.Second, "initial delay before retrying a failed whitelist fetch")
+	strictRequestBodyPtr := flag.Bool("strictRequestBody", false, "reject request bodies with data after the JSON value")
+	signatureModePtr := flag.String("signatureMode", "required", "off, optional or required; only required enforces the whitelist, optional verifies signatures when present")
+	addressFormatPtr := flag.String("addressFormat", "lowercase", "lowercase or checksum (EIP-55) addresses in logs, responses and stats")
+	echoSenderPtr := flag.Bool("echoSender", false, "include the recovered sender in responses")
+	debugRecoverSignerPtr := flag.Bool("debugRecoverSigner", false, "serve mev_recoverSigner, returning the recovered pubkey and address of a signature")
+	requireTimestampsPtr := flag.Bool("requireTimestamps", false, "reject bundles without minTimestamp and maxTimestamp")
+	checkTimestampWindowPtr := flag.Bool("checkTimestampWindow", false, "reject negative timestamps and minTimestamp after maxTimestamp. Off by default, as it parses every bundle and refuses ones in a shape the proxy does not understand")
+	maxSignatureAgePtr := flag.Uint64("maxSignatureAge", 0, "max blocks a bundle's signedBlock may trail the head, 0 to not require signedBlock")
+	strictBlockTargetPtr := flag.Bool("strictBlockTarget", false, "only accept bundles targeting the block after the head")
+	staleBlockPolicyPtr := flag.String("staleBlockPolicy", "", "reject or retarget bundles targeting the current head, empty to forward them as is")
+	maxClockDriftPtr := flag.Duration("maxClockDrift", 0, "warn when the clock and latest block timestamp differ by more, 0 to not check")
+	headPollIntervalPtr := flag.Duration("headPollInterval", 0, "time between upstream head polls, 0 to not track the head")
+	maxQueuedPerAddressPtr := flag.Int64("maxQueuedPerAddress", 0, "max bundles a signer can have pending at the upstream, 0 for no limit")
+	capLogIntervalPtr := flag.Duration("capLogInterval", 10*time.Second, "min time between logs of a signer hitting maxQueuedPerAddress, 0 to disable")
+	reusePortPtr := flag.Bool("reusePort", false, "bind with SO_REUSEPORT so another process can take over the port (linux amd64, arm64, 386 and arm)")
+	listenBacklogPtr := flag.Int("listenBacklog", 0, "TCP listen backlog, 0 for the system default (linux amd64, arm64, 386 and arm)")
+	connRatePerIPPtr := flag.Float64("connRatePerIP", 0, "new connections per second allowed from one IP, 0 for no limit. The IP is the TCP peer, forwarded client IP headers are not supported, so behind a load balancer every client shares its IP")
+	connBurstPerIPPtr := flag.Int("connBurstPerIP", 20, "connections one IP can open in a burst when connRatePerIP is set")
+	idempotencyTTLPtr := flag.Duration("idempotencyTTL", 0, "how long Idempotency-Key responses are remembered per signer, 0 to ignore keys. Keys on unsigned requests are always ignored")
+	idempotencyCacheSizePtr := flag.Int("idempotencyCacheSize", 10000, "max remembered Idempotency-Key responses")
+	adminAddrPtr := flag.String("adminAddr", "127.0.0.1:18546", "listen address for /admin endpoints, admin is disabled when empty")
+	metricsAddrPtr := flag.String("metricsAddr", "", "listen address for /metrics, counters are served under /admin/vars when empty")
+	adminTokenPtr := flag.String("adminToken", "", "bearer token for /admin endpoints, admin is disabled when empty")
+	drainFilePtr := flag.String("drainFile", "", "drain while this file exists, not watched when empty")
+	drainFileIntervalPtr := flag.Duration("drainFileInterval", 5*time.Second, "time between checks for drainFile")
+	statsPersistPathPtr := flag.String("statsPersistPath", "", "file counters are restored from at startup and saved to on shutdown")
+	selfTestPtr := flag.Bool("selfTest", false, "run startup checks once and exit")
 
 	flag.Parse()
 
-	fmt.Printf("Starting gateway with listenAddr: %s, rpcAddr: %s\n", *listenAddrPtr, *rpcAddrPtr)
+	methodRoutes, err := parseMethodRoutes(*methodRoutesPtr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	latencyBuckets, err := parseLatencyBuckets(*latencyBucketsPtr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var signingKey []byte
+	if *signingKeyPathPtr != "" {
+		signingKey, err = loadSigningKey(*signingKeyPathPtr)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	g := &Proxy{
+		RpcAddr:                  *rpcAddrPtr,
+		MethodRoutes:             methodRoutes,
+		SubmissionPaths:          strings.Split(*submissionPathsPtr, ","),
+		PassthroughAll:           *passthroughAllPtr,
+		MirrorAddr:               *mirrorAddrPtr,
+		LatencyBuckets:           latencyBuckets,
+		MaxUpstreamBodyBytes:     *maxUpstreamBodyBytesPtr,
+		SigningKey:               signingKey,
+		SignatureHeader:          *signatureHeaderPtr,
+		ValidatorAddress:         *validatorAddressPtr,
+		ValidatorSignatureHeader: *validatorSignatureHeaderPtr,
+		SignReceipts:             *signReceiptsPtr,
+		SubgraphPath:             *subgraphPathPtr,
+		WhitelistURL:             *whitelistURLPtr,
+		WhitelistSigner:          *whitelistSignerPtr,
+		MaxWhitelistShrink:       *maxWhitelistShrinkPtr,
+		WhitelistRefreshInterval: *whitelistRefreshIntervalPtr,
+		WhitelistRetryDelay:      *whitelistRetryDelayPtr,
+		StrictRequestBody:        *strictRequestBodyPtr,
+		SignatureMode:            *signatureModePtr,
+		AddressFormat:            *addressFormatPtr,
+		EchoSender:               *echoSenderPtr,
+		DebugRecoverSigner:       *debugRecoverSignerPtr,
+		RequireTimestamps:        *requireTimestampsPtr,
+		CheckTimestampWindow:     *checkTimestampWindowPtr,
+		MaxSignatureAge:          *maxSignatureAgePtr,
+		StrictBlockTarget:        *strictBlockTargetPtr,
+		StaleBlockPolicy:         *staleBlockPolicyPtr,
+		MaxClockDrift:            *maxClockDriftPtr,
+		HeadPollInterval:         *headPollIntervalPtr,
+		MaxQueuedPerAddress:      *maxQueuedPerAddressPtr,
+		CapLogInterval:           *capLogIntervalPtr,
+		ReusePort:                *reusePortPtr,
+		ListenBacklog:            *listenBacklogPtr,
+		ConnRatePerIP:            *connRatePerIPPtr,
+		ConnBurstPerIP:           *connBurstPerIPPtr,
+		IdempotencyTTL:           *idempotencyTTLPtr,
+		IdempotencyCacheSize:     *idempotencyCacheSizePtr,
+		AdminAddr:                *adminAddrPtr,
+		MetricsAddr:              *metricsAddrPtr,
+		AdminToken:               *adminTokenPtr,
+		DrainFile:                *drainFilePtr,
+		DrainFileInterval:        *drainFileIntervalPtr,
+		StatsPersistPath:         *statsPersistPathPtr,
+	}
 
-	g := &Proxy{*rpcAddrPtr, nil, *subgraphPathPtr}
+	err = g.Validate(*listenAddrPtr)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *selfTestPtr {
+		if !g.SelfTest() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Printf("Starting gateway with listenAddr: %s, rpcAddr: %s\n", *listenAddrPtr, *rpcAddrPtr)
 	g.ListenAndServe(*listenAddrPtr)
 }
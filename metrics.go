@@ -0,0 +1,102 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Counters, served as JSON at /metrics on metricsAddr or /admin/vars
+var (
+	startTime = time.Now()
+
+	// received, accepted, dispatched, failed or replayed -> requests
+	requestCounts = expvar.NewMap("requests")
+	// reason -> requests rejected before reaching a method handler
+	rejections = expvar.NewMap("rejections")
+	// whitelisted address, or unlisted for the rest -> bundles rejected for
+	// exceeding maxQueuedPerAddress
+	pendingCapRejections = expvar.NewMap("pendingCapRejections")
+	// failure type -> requests rejected for a bad X-Marlin-Signature
+	sigFailures = expvar.NewMap("sigFailures")
+	// connections closed for exceeding connRatePerIP
+	connRejections = expvar.NewInt("connRejections")
+	// whitelist fetches refused for shrinking more than maxWhitelistShrink
+	whitelistShrinkRejections = expvar.NewInt("whitelistShrinkRejections")
+	// failure type -> bundle responses not signed by validatorAddress
+	validatorAckFailures = expvar.NewMap("validatorAckFailures")
+	// accepted bundles not mirrored because the mirror queue was full
+	mirrorDrops = expvar.NewInt("mirrorDrops")
+	// mirror posts that failed or got a non 2xx status
+	mirrorFailures = expvar.NewInt("mirrorFailures")
+	// forwarded requests that could not be signed with the proxy key
+	signingFailures = expvar.NewInt("signingFailures")
+	// upstream responses whose id did not match the request
+	upstreamIdMismatches = expvar.NewInt("upstreamIdMismatches")
+	// upstream -> bundle delivery latency histogram, see latencyBuckets
+	deliveryLatency = &latencyHistogram{}
+	// head polls that saw a lower block number than the last one
+	headRegressions = expvar.NewInt("headRegressions")
+	// milliseconds the local clock is ahead of the latest block timestamp
+	clockDrift = expvar.NewInt("clockDriftMs")
+	// head polls where clockDrift was over maxClockDrift
+	clockDriftWarnings = expvar.NewInt("clockDriftWarnings")
+)
+
+type Stats struct {
+	Uptime float64 `json:"uptime"`
+	// Requests past the method and path check
+	Received int64 `json:"received"`
+	// eth_sendBundle requests from whitelisted signers
+	Accepted   int64 `json:"accepted"`
+	Dispatched int64 `json:"dispatched"`
+	Failed     int64 `json:"failed"`
+	// Idempotency-Key repeats answered with the first response, these
+	// are not counted as accepted again
+	Replayed int64 `json:"replayed"`
+	// reason -> count, signature failures broken out by type
+	Rejected map[string]int64 `json:"rejected"`
+	Inflight int64            `json:"inflight"`
+	// Seconds since the whitelist was last replaced, -1 if never loaded
+	WhitelistAge float64 `json:"whitelistAge"`
+}
+
+func counter(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func (p *Proxy) stats() *Stats {
+	stats := &Stats{
+		Uptime:       time.Since(startTime).Seconds(),
+		Received:     counter(requestCounts, "received"),
+		Accepted:     counter(requestCounts, "accepted"),
+		Dispatched:   counter(requestCounts, "dispatched"),
+		Failed:       counter(requestCounts, "failed"),
+		Replayed:     counter(requestCounts, "replayed"),
+		Rejected:     make(map[string]int64),
+		Inflight:     atomic.LoadInt64(&p.inflight),
+		WhitelistAge: p.readyStatus().WhitelistAge,
+	}
+
+	rejections.Do(func(kv expvar.KeyValue) {
+		stats.Rejected[kv.Key] = kv.Value.(*expvar.Int).Value()
+	})
+	sigFailures.Do(func(kv expvar.KeyValue) {
+		stats.Rejected["signature:"+kv.Key] = kv.Value.(*expvar.Int).Value()
+	})
+
+	return stats
+}
+
+func (p *Proxy) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(404)
+		return
+	}
+
+	writeJSON(w, 200, p.stats())
+}
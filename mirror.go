@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// mirrorQueueSize bounds the bundles waiting to be mirrored, further ones
+// are dropped
+const mirrorQueueSize = 1000
+
+// MirroredBundle is the body posted to MirrorAddr for each accepted bundle
+type MirroredBundle struct {
+	Sender string `json:"sender"`
+	// Unix milliseconds the bundle was accepted at
+	Time    int64   `json:"time"`
+	Request *RpcReq `json:"request"`
+}
+
+// mirror queues a copy of an accepted bundle for MirrorAddr. It never
+// blocks, the bundle is dropped if the queue is full.
+func (p *Proxy) mirror(addr string, req *RpcReq) {
+	if p.MirrorAddr == "" {
+		return
+	}
+
+	body, err := json.Marshal(&MirroredBundle{
+		p.formatAddress(addr),
+		time.Now().UnixNano() / int64(time.Millisecond),
+		req,
+	})
+	if err != nil {
+		return
+	}
+
+	select {
+	case p.mirrorQueue <- body:
+	default:
+		mirrorDrops.Add(1)
+	}
+}
+
+// runMirror posts queued bundles to MirrorAddr one at a time. Failures
+// are only counted, the mirror never affects dispatch.
+func (p *Proxy) runMirror() {
+	ctx, cancel := p.stopContext()
+	defer cancel()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for {
+		select {
+		case <-p.stopped():
+			return
+		case body := <-p.mirrorQueue:
+			req, err := http.NewRequestWithContext(ctx, "POST", p.MirrorAddr, bytes.NewReader(body))
+			if err != nil {
+				mirrorFailures.Add(1)
+				continue
+			}
+			req.Header.Set("Content-Type", "application/json")
+			r, err := client.Do(req)
+			if err != nil {
+				mirrorFailures.Add(1)
+				continue
+			}
+			io.Copy(ioutil.Discard, r.Body)
+			r.Body.Close()
+			if r.StatusCode < 200 || r.StatusCode > 299 {
+				mirrorFailures.Add(1)
+			}
+		}
+	}
+}
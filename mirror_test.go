@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMirrorPostsAcceptedBundles(t *testing.T) {
+	mirrored := make(chan *MirroredBundle, 1)
+	sidecar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bundle := &MirroredBundle{}
+		json.Unmarshal(body, bundle)
+		mirrored <- bundle
+	}))
+	defer sidecar.Close()
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.MirrorAddr = sidecar.URL
+	p.mirrorQueue = make(chan []byte, mirrorQueueSize)
+	p.spawn(p.runMirror)
+	defer p.Stop()
+
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	select {
+	case bundle := <-mirrored:
+		if bundle.Sender != keyAddr(t, testKey) || bundle.Request.Method != "eth_sendBundle" || string(bundle.Request.Params) != testBundle {
+			t.Fatalf("mirrored %+v", bundle)
+		}
+		if age := time.Now().UnixNano()/int64(time.Millisecond) - bundle.Time; age < 0 || age > 5000 {
+			t.Fatalf("mirrored with time %d", bundle.Time)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bundle not mirrored")
+	}
+}
+
+func TestMirrorFailuresDoNotAffectDispatch(t *testing.T) {
+	posted := make(chan struct{}, 1)
+	sidecar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		posted <- struct{}{}
+	}))
+	defer sidecar.Close()
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.MirrorAddr = sidecar.URL
+	p.mirrorQueue = make(chan []byte, mirrorQueueSize)
+
+	failures := intVar("mirrorFailures")
+	p.spawn(p.runMirror)
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error != nil {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	<-posted
+	p.Stop()
+	if intVar("mirrorFailures") != failures+1 {
+		t.Fatal("mirror failure not counted")
+	}
+}
+
+func TestMirrorDropsWhenQueueFull(t *testing.T) {
+	p := newTestProxy("")
+	p.MirrorAddr = "http://mirror.invalid"
+	p.mirrorQueue = make(chan []byte, 1)
+	req := &RpcReq{"2.0", "eth_sendBundle", []byte(testBundle), []byte("1")}
+
+	drops := intVar("mirrorDrops")
+	p.mirror("0xa", req)
+	p.mirror("0xa", req)
+	if intVar("mirrorDrops") != drops+1 || len(p.mirrorQueue) != 1 {
+		t.Fatalf("%d dropped, %d queued", intVar("mirrorDrops")-drops, len(p.mirrorQueue))
+	}
+}
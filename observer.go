@@ -0,0 +1,28 @@
+package main
+
+// DispatchObserver is told what happens to each eth_sendBundle from a
+// whitelisted signer, for integrators wiring up their own accounting.
+// Calls are made synchronously on the request goroutine.
+type DispatchObserver interface {
+	// OnAccepted is called once the signer has passed the whitelist
+	OnAccepted(addr string, req *RpcReq)
+	// OnDispatched is called with the upstream's response, which may be
+	// an error
+	OnDispatched(addr string, req *RpcReq, resp *RpcResp)
+	// OnDropped is called instead of OnDispatched when the bundle is not
+	// forwarded, reason is invalidParams or pendingCap
+	OnDropped(addr string, req *RpcReq, reason string)
+}
+
+type NopObserver struct{}
+
+func (NopObserver) OnAccepted(addr string, req *RpcReq)                  {}
+func (NopObserver) OnDispatched(addr string, req *RpcReq, resp *RpcResp) {}
+func (NopObserver) OnDropped(addr string, req *RpcReq, reason string)    {}
+
+func (p *Proxy) observer() DispatchObserver {
+	if p.Observer == nil {
+		return NopObserver{}
+	}
+	return p.Observer
+}
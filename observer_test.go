@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (o *recordingObserver) OnAccepted(addr string, req *RpcReq) {
+	o.events = append(o.events, "accepted "+addr)
+}
+
+func (o *recordingObserver) OnDispatched(addr string, req *RpcReq, resp *RpcResp) {
+	o.events = append(o.events, fmt.Sprintf("dispatched %s %v", addr, resp.Error == nil))
+}
+
+func (o *recordingObserver) OnDropped(addr string, req *RpcReq, reason string) {
+	o.events = append(o.events, "dropped "+addr+" "+reason)
+}
+
+func TestDispatchObserver(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	observer := &recordingObserver{}
+	p.Observer = observer
+	addr := keyAddr(t, testKey)
+
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	p.RequireTimestamps = true
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	// not whitelisted, never accepted
+	postRpc(t, p, "eth_sendBundle", testBundle, otherKey)
+
+	want := []string{
+		"accepted " + addr,
+		"dispatched " + addr + " true",
+		"accepted " + addr,
+		"dropped " + addr + " invalidParams",
+	}
+	if fmt.Sprint(observer.events) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", observer.events, want)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// PersistedStats is the on-disk form of the expvar counters. Counters are
+// keyed by expvar name, so ones added or removed between versions are
+// simply absent or skipped on load.
+type PersistedStats struct {
+	Version int                        `json:"version"`
+	Vars    map[string]json.RawMessage `json:"vars"`
+}
+
+// unpersisted are expvars that hold a current reading rather than a count,
+// adding a saved one onto the live value would be meaningless
+var unpersisted = map[string]bool{
+	"clockDriftMs": true,
+}
+
+func saveStats(path string) error {
+	stats := &PersistedStats{1, make(map[string]json.RawMessage)}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if unpersisted[kv.Key] {
+			return
+		}
+		switch kv.Value.(type) {
+		case *expvar.Int, *expvar.Map:
+			stats.Vars[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+
+	statsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	// write then rename, so a crash mid-write keeps the previous file
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, statsBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// loadStats adds the counters saved at path onto the current ones
+func loadStats(path string) error {
+	statsBytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	stats := &PersistedStats{}
+	err = json.Unmarshal(statsBytes, stats)
+	if err != nil {
+		return fmt.Errorf("stats file %s: %s", path, err)
+	}
+
+	for name, raw := range stats.Vars {
+		// files from before unpersisted was kept may still hold gauges
+		if unpersisted[name] {
+			continue
+		}
+		switch v := expvar.Get(name).(type) {
+		case *expvar.Int:
+			var count int64
+			if json.Unmarshal(raw, &count) == nil {
+				v.Add(count)
+			}
+		case *expvar.Map:
+			var counts map[string]int64
+			if json.Unmarshal(raw, &counts) == nil {
+				for key, count := range counts {
+					v.Add(key, count)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// persistStatsOnExit restores stats from StatsPersistPath and saves them
+// back there when the process is told to stop
+func (p *Proxy) persistStatsOnExit() {
+	err := loadStats(p.StatsPersistPath)
+	if err != nil {
+		fmt.Println("stats load err", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sig
+		err := p.stopAndSaveStats()
+		if err != nil {
+			fmt.Println("stats save err", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+// stopAndSaveStats stops the background pollers before saving, so counts
+// from a fetch that was still running are not lost
+func (p *Proxy) stopAndSaveStats() error {
+	p.Stop()
+	return saveStats(p.StatsPersistPath)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatsRoundTripSkipsGauges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	clockDrift.Set(250)
+	mirrorDrops.Add(3)
+	err := saveStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statsBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := &PersistedStats{}
+	err = json.Unmarshal(statsBytes, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats.Vars["clockDriftMs"]; ok {
+		t.Fatal("clockDriftMs was saved")
+	}
+	if _, ok := stats.Vars["mirrorDrops"]; !ok {
+		t.Fatal("mirrorDrops was not saved")
+	}
+
+	drops := intVar("mirrorDrops")
+	err = loadStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intVar("mirrorDrops") != 2*drops {
+		t.Fatalf("mirrorDrops %d after load, want %d", intVar("mirrorDrops"), 2*drops)
+	}
+	if intVar("clockDriftMs") != 250 {
+		t.Fatalf("clockDriftMs %d after load, want it untouched", intVar("clockDriftMs"))
+	}
+}
+
+func TestLoadStatsIgnoresSavedGauges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	err := ioutil.WriteFile(path, []byte(`{"version":1,"vars":{"clockDriftMs":1000}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clockDrift.Set(5)
+	err = loadStats(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intVar("clockDriftMs") != 5 {
+		t.Fatalf("clockDriftMs %d, want the live reading", intVar("clockDriftMs"))
+	}
+}
+
+func TestLoadStatsMissingFile(t *testing.T) {
+	err := loadStats(filepath.Join(t.TempDir(), "absent.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownSavesCountsFromRunningPollers(t *testing.T) {
+	started := make(chan struct{}, 1)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the close is only noticed once the body is read
+		ioutil.ReadAll(r.Body)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer mirror.Close()
+
+	p := newTestProxy("")
+	p.StatsPersistPath = filepath.Join(t.TempDir(), "stats.json")
+	p.MirrorAddr = mirror.URL
+	p.mirrorQueue = make(chan []byte, 1)
+	p.mirrorQueue <- []byte("{}")
+	p.spawn(p.runMirror)
+	<-started
+
+	// the post cancelled by shutdown is counted as a mirror failure
+	failures := intVar("mirrorFailures")
+	err := p.stopAndSaveStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intVar("mirrorFailures") != failures+1 {
+		t.Fatalf("mirrorFailures %d, want %d", intVar("mirrorFailures"), failures+1)
+	}
+
+	// what the next process would load
+	err = loadStats(p.StatsPersistPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intVar("mirrorFailures") != 2*(failures+1) {
+		t.Fatalf("mirrorFailures %d after load, want the saved %d added", intVar("mirrorFailures"), failures+1)
+	}
+}
@@ -2,35 +2,159 @@ package main
 
 import (
 	"bytes"
-	"encoding/hex"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
+	"net"
 	"net/http"
-	"sort"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
-
-	"github.com/ethereum/go-ethereum/crypto/secp256k1"
-	"golang.org/x/crypto/sha3"
 )
 
 type Proxy struct {
 	RpcAddr string
+	// Paths accepting JSON-RPC requests, others get a JSON 404
+	SubmissionPaths []string
+	// Client method -> upstream address, methods not listed go to RpcAddr
+	MethodRoutes map[string]string
+	// Forward any other non bundle method from a whitelisted signer to the
+	// upstream as is
+	PassthroughAll bool
+	// Notified of each bundle's outcome, nil for none
+	Observer DispatchObserver
+	// Sidecar sent a copy of each accepted bundle, empty for none
+	MirrorAddr  string
+	mirrorQueue chan []byte
+	// Upper bounds of the delivery latency histogram buckets, empty for none
+	LatencyBuckets []time.Duration
+	// Extra admission check after the whitelist, nil for whitelist only
+	Authorizer Authorizer
+	// Max size of a request forwarded upstream, 0 for no limit
+	MaxUpstreamBodyBytes int
+	// Proxy private key signing forwarded requests, nil to forward unsigned
+	SigningKey []byte
+	// Header carrying the proxy signature to the upstream
+	SignatureHeader string
+	// Address that must sign bundle delivery responses, empty to trust them
+	ValidatorAddress string
+	// Header carrying the validator signature on delivery responses
+	ValidatorSignatureHeader string
+	// Add a receipt signed by SigningKey to accepted bundle responses
+	SignReceipts bool
 	// We will atomically update this to avoid explicit locks
 	// In modern systems, should avoid _any_ locks
+	// Points to a map[string]struct{} of lowercase addresses
 	Whitelist    unsafe.Pointer
 	SubgraphPath string
+	// Version of the current signed whitelist file, 0 for the subgraph
+	whitelistVersion uint64
+	// Unix nanos of the last whitelist replacement, 0 if never loaded
+	whitelistUpdated int64
+	// Signed whitelist file to use instead of the subgraph, if set
+	WhitelistURL string
+	// Address that must have signed the WhitelistURL file
+	WhitelistSigner string
+	// Max percentage the whitelist may shrink by in one fetch, 100 to disable
+	MaxWhitelistShrink float64
+	// 1 to let the next successful fetch through the shrink guard
+	allowShrink int32
+	// Time between successful whitelist fetches
+	WhitelistRefreshInterval time.Duration
+	// Initial delay before retrying a failed whitelist fetch, doubled on each failure
+	WhitelistRetryDelay time.Duration
+	// Reject request bodies with data after the JSON value
+	StrictRequestBody bool
+	// off, optional or required, only required enforces the whitelist
+	SignatureMode string
+	// lowercase or checksum (EIP-55) form of addresses in logs, responses and stats
+	AddressFormat string
+	// Echo the recovered signer back to the client, for debugging signing issues
+	EchoSender bool
+	// Serve mev_recoverSigner, returning the recovered key without forwarding
+	DebugRecoverSigner bool
+	// Reject bundles without an explicit validity window
+	RequireTimestamps bool
+	// Reject negative timestamps and inverted validity windows. Opt in, like
+	// the other admission checks, as it means parsing every bundle.
+	CheckTimestampWindow bool
+	// Max blocks a bundle's signedBlock may trail the head, 0 to not require it
+	MaxSignatureAge uint64
+	// Only accept bundles targeting the block after the head
+	StrictBlockTarget bool
+	// Reject or retarget bundles aimed at the current head, empty to allow
+	StaleBlockPolicy string
+	// Warn when the clock and latest block timestamp differ by more, 0 to not check
+	MaxClockDrift time.Duration
+	// Time between upstream head polls, 0 to not track the head
+	HeadPollInterval time.Duration
+	// Latest upstream block number, 0 until known
+	head uint64
+	// Max bundles a signer can have pending at the upstream, 0 for no limit
+	MaxQueuedPerAddress int64
+	// address -> *int64 count of pending bundles
+	pending sync.Map
+	// Min time between logs of a sender hitting the cap, 0 disables them
+	CapLogInterval time.Duration
+	// address -> *int64 unix nanos of the last cap log
+	capLogged sync.Map
+	// How long Idempotency-Key responses are remembered, 0 disables keys
+	IdempotencyTTL time.Duration
+	// Max remembered Idempotency-Key responses
+	IdempotencyCacheSize int
+	idempotency          idempotencyCache
+	// Bind every listener with SO_REUSEPORT, for handovers between processes
+	ReusePort bool
+	// TCP listen backlog, 0 for the system default
+	ListenBacklog int
+	// New connections per second allowed from one IP, 0 for no limit. The
+	// IP is the TCP peer, behind a load balancer that is the balancer's.
+	ConnRatePerIP float64
+	// Connections one IP can open at once before ConnRatePerIP applies
+	ConnBurstPerIP int
+	// Listen address for /admin endpoints, admin is disabled when empty
+	AdminAddr string
+	// Listen address for /metrics, counters are under /admin/vars when empty
+	MetricsAddr string
+	// Bearer token for /admin endpoints, admin is disabled when empty
+	AdminToken string
+	// 1 once intake is stopped over /admin/drain, or while DrainFile exists.
+	// Kept apart so removing the file never undoes an admin drain.
+	drainedByAdmin int32
+	drainedByFile  int32
+	// Counters are restored from and saved to this file, not kept when empty
+	StatsPersistPath string
+	// Drain while this file exists, not watched when empty
+	DrainFile string
+	// Time between checks for DrainFile
+	DrainFileInterval time.Duration
+	// Requests currently being handled
+	inflight int64
+	// Set by ListenAndServe, for config export
+	listenAddr string
+	// Closed by Stop to end background pollers
+	stop      chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	pollers   sync.WaitGroup
 }
 
 type RpcReq struct {
 	Jsonrpc string          `json:"jsonrpc"`
 	Method  string          `json:"method"`
 	Params  json.RawMessage `json:"params"`
-	Id      interface{}     `json:"id"`
+	Id      json.RawMessage `json:"id"` // raw, so large ids are echoed without float64 rounding
 }
 
 type RpcErr struct {
@@ -40,32 +164,97 @@ type RpcErr struct {
 }
 
 type RpcResp struct {
-	Jsonrpc string      `json:"jsonrpc"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *RpcErr     `json:"error,omitempty"`
-	Id      interface{} `json:"id"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *RpcErr         `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
 }
 
-func makeRpcCall(req *RpcReq, rpcAddr string) *RpcResp {
+// isConnDrop reports whether reading a response body failed because the
+// connection was reset or closed early. Only read errors are checked, an
+// empty or truncated body that was fully received is malformed instead.
+func isConnDrop(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, net.ErrClosed) ||
+		// the transport saw the body end before its Content-Length or
+		// final chunk
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// makeRpcCall forwards req to rpcAddr, refusing bodies over
+// MaxUpstreamBodyBytes and signing them with SigningKey if set. The call
+// is aborted if ctx is done.
+func (p *Proxy) makeRpcCall(ctx context.Context, req *RpcReq, rpcAddr string) *RpcResp {
 	reqBytes, _ := json.Marshal(req)
-	r, err := http.Post(rpcAddr, "application/json", bytes.NewReader(reqBytes))
+	maxBodyBytes := p.MaxUpstreamBodyBytes
+	if maxBodyBytes > 0 && len(reqBytes) > maxBodyBytes {
+		return &RpcResp{
+			"2.0",
+			nil,
+			&RpcErr{
+				-32602,
+				fmt.Sprintf("Request of %d bytes exceeds upstream limit of %d", len(reqBytes), maxBodyBytes),
+				nil,
+			},
+			req.Id,
+		}
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", rpcAddr, bytes.NewReader(reqBytes))
+	if err == nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if err == nil && p.SigningKey != nil {
+		sig, err := p.sign(reqBytes)
+		if err != nil {
+			// our own misconfiguration, not the upstream's
+			signingFailures.Add(1)
+			fmt.Println("request sign err", err)
+			return &RpcResp{
+				"2.0",
+				nil,
+				&RpcErr{
+					-32603,
+					"Request signing failed",
+					nil,
+				},
+				req.Id,
+			}
+		}
+		httpReq.Header.Set(p.SignatureHeader, sig)
+	}
+	var r *http.Response
+	if err == nil {
+		r, err = http.DefaultClient.Do(httpReq)
+	}
 
+	if err != nil && ctx.Err() != nil {
+		fmt.Println("upstream call aborted", ctx.Err())
+	}
 	if err != nil {
 		return &RpcResp{
 			"2.0",
 			nil,
 			&RpcErr{
-				-32603,
-				"Upstream unreachable",
+				-32003,
+				"Upstream unavailable",
 				nil,
 			},
 			req.Id,
 		}
 	}
+	defer r.Body.Close()
 
 	// WARN: Should ideally use Content-Length here but the RPC server does not send it
 	bodyLength := 1000000
-	if r.Header.Get("Content-Type") != "application/json" ||
+	// Upstreams may add parameters like charset, which should not hide their errors
+	if !isJSON(r.Header.Get("Content-Type")) ||
 		bodyLength <= 0 {
 		return &RpcResp{
 			"2.0",
@@ -79,10 +268,27 @@ func makeRpcCall(req *RpcReq, rpcAddr string) *RpcResp {
 		}
 	}
 
-	decoder := json.NewDecoder(io.LimitReader(r.Body, int64(bodyLength)))
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(bodyLength)))
+	if isConnDrop(err) {
+		// Upstream went away mid-response, the request itself may be fine
+		fmt.Println("upstream connection closed mid-response", err)
+		return &RpcResp{
+			"2.0",
+			nil,
+			&RpcErr{
+				-32603,
+				"Upstream connection closed",
+				nil,
+			},
+			req.Id,
+		}
+	}
 	var resp *RpcResp = &RpcResp{}
-	err = decoder.Decode(resp)
+	if err == nil {
+		err = json.NewDecoder(bytes.NewReader(body)).Decode(resp)
+	}
 	if err != nil || resp.Jsonrpc != "2.0" {
+		fmt.Println("upstream response malformed", err)
 		return &RpcResp{
 			"2.0",
 			nil,
@@ -94,10 +300,61 @@ func makeRpcCall(req *RpcReq, rpcAddr string) *RpcResp {
 			req.Id,
 		}
 	}
+	// Only bundle deliveries are acknowledged by the validator
+	if p.ValidatorAddress != "" && req.Method == "mev_sendBundle" {
+		kind, err := p.verifyValidatorAck(r.Header.Get(p.ValidatorSignatureHeader), body)
+		if err != nil {
+			validatorAckFailures.Add(kind, 1)
+			fmt.Println("validator", err)
+			return &RpcResp{
+				"2.0",
+				nil,
+				&RpcErr{
+					-32603,
+					"Upstream acknowledgment not signed by the validator",
+					nil,
+				},
+				req.Id,
+			}
+		}
+	}
+	// A confused upstream may answer with another request's response
+	if !sameId(resp.Id, req.Id) {
+		upstreamIdMismatches.Add(1)
+		fmt.Printf("upstream response id %s does not match request id %s\n", resp.Id, req.Id)
+		return &RpcResp{
+			"2.0",
+			nil,
+			&RpcErr{
+				-32603,
+				"Upstream response id mismatch",
+				nil,
+			},
+			req.Id,
+		}
+	}
 
 	return resp
 }
 
+// sameId compares JSON-RPC ids ignoring insignificant whitespace. An
+// absent id is the same as null, upstreams answer requests without one
+// with "id": null.
+func sameId(a, b json.RawMessage) bool {
+	return bytes.Equal(compactId(a), compactId(b))
+}
+
+func compactId(id json.RawMessage) []byte {
+	if len(bytes.TrimSpace(id)) == 0 {
+		return []byte("null")
+	}
+	var c bytes.Buffer
+	if json.Compact(&c, id) != nil {
+		return id
+	}
+	return c.Bytes()
+}
+
 type WhitelistResp struct {
 	Data struct {
 		Keystores []struct {
@@ -106,50 +363,260 @@ type WhitelistResp struct {
 	} `json:"data"`
 }
 
-func (p *Proxy) fetchWhitelist() ([]string, error) {
+// fetchWhitelist returns the whitelisted addresses and their version,
+// which is always 0 for the subgraph
+func (p *Proxy) fetchWhitelist(ctx context.Context) ([]string, uint64, error) {
+	if p.WhitelistURL != "" {
+		return p.fetchSignedWhitelist(ctx)
+	}
+
 	graphURL := "https://api.thegraph.com/subgraphs/name" + p.SubgraphPath
 	reqBytes := []byte(`{"query": "query { keystores { key } }"}`)
 	// fmt.Println(string(reqBytes))
-	r, err := http.Post(graphURL, "application/json", bytes.NewReader(reqBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", graphURL, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Setting this ourselves disables transparent decompression in the transport
+	req.Header.Set("Accept-Encoding", "gzip")
 
+	r, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	defer r.Body.Close()
 
 	// WARN: Should ideally use Content-Length here but the RPC server does not send it
 	bodyLength := 1000000
 	// fmt.Println(r)
 	if r.Header.Get("content-type") != "application/json" ||
 		bodyLength <= 0 {
-		return nil, fmt.Errorf("Response content type mismatch")
+		return nil, 0, fmt.Errorf("Response content type mismatch")
 	}
 
-	decoder := json.NewDecoder(io.LimitReader(r.Body, int64(bodyLength)))
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, 0, fmt.Errorf("Response gzip error")
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// Limit applies to the decompressed stream
+	decoder := json.NewDecoder(io.LimitReader(body, int64(bodyLength)))
 	resp := &WhitelistResp{}
 	err = decoder.Decode(resp)
 	if err != nil {
-		return nil, fmt.Errorf("Response decode error")
+		return nil, 0, fmt.Errorf("Response decode error")
 	}
 
 	// Are we List.map yet instead of this abomination?
 	keys := make([]string, len(resp.Data.Keystores))
 	for idx, keyResp := range resp.Data.Keystores {
-		keys[idx] = keyResp.Key
+		// lookups compare lowercase addresses
+		keys[idx] = strings.ToLower(keyResp.Key)
 	}
 	// fmt.Println(keys)
-	return keys, nil
+	return keys, 0, nil
+}
+
+// acquirePending reserves one of the signer's pending slots, returning
+// false if the signer is already at its cap
+func (p *Proxy) acquirePending(addr string) bool {
+	if p.MaxQueuedPerAddress <= 0 {
+		return true
+	}
+
+	for {
+		v, _ := p.pending.LoadOrStore(addr, new(int64))
+		count := v.(*int64)
+		n := atomic.LoadInt64(count)
+		if n < 0 {
+			// being removed by releasePending, retry with a fresh counter
+			runtime.Gosched()
+			continue
+		}
+		if n >= p.MaxQueuedPerAddress {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(count, n, n+1) {
+			return true
+		}
+	}
+}
+
+// capRejectionKey labels pendingCapRejections by sender for whitelisted
+// senders only, so senders let through unlisted by signatureMode cannot
+// grow the map without bound
+func (p *Proxy) capRejectionKey(addr string) string {
+	whitelist := (*map[string]struct{})(atomic.LoadPointer(&p.Whitelist))
+	if _, ok := (*whitelist)[addr]; !ok {
+		return "unlisted"
+	}
+	return p.formatAddress(addr)
+}
+
+// logCapRejection counts a pending cap rejection, logging it at most
+// once per CapLogInterval per sender
+func (p *Proxy) logCapRejection(addr string) {
+	pendingCapRejections.Add(p.capRejectionKey(addr), 1)
+	if p.CapLogInterval <= 0 {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	v, _ := p.capLogged.LoadOrStore(addr, new(int64))
+	last := v.(*int64)
+	prev := atomic.LoadInt64(last)
+	if now-prev < int64(p.CapLogInterval) ||
+		!atomic.CompareAndSwapInt64(last, prev, now) {
+		return
+	}
+
+	fmt.Println("Pending cap reached for", p.formatAddress(addr),
+		"limit:", p.MaxQueuedPerAddress,
+		"total rejections:", pendingCapRejections.Get(p.capRejectionKey(addr)))
+}
+
+// releasePending frees a pending slot. A signer's counter is removed once
+// it reaches zero, marked -1 first so acquirePending never reuses a
+// counter that is on its way out.
+func (p *Proxy) releasePending(addr string) {
+	if p.MaxQueuedPerAddress <= 0 {
+		return
+	}
+
+	v, _ := p.pending.Load(addr)
+	count := v.(*int64)
+	if atomic.AddInt64(count, -1) != 0 || !atomic.CompareAndSwapInt64(count, 0, -1) {
+		return
+	}
+	p.pending.Delete(addr)
+
+	// The log throttle only matters while the signer is at its cap
+	if v, ok := p.capLogged.Load(addr); ok &&
+		time.Now().UnixNano()-atomic.LoadInt64(v.(*int64)) >= int64(p.CapLogInterval) {
+		p.capLogged.Delete(addr)
+	}
+}
+
+// upstreamFor returns the upstream a client method is forwarded to
+func (p *Proxy) upstreamFor(method string) string {
+	if upstream, ok := p.MethodRoutes[method]; ok {
+		return upstream
+	}
+
+	return p.RpcAddr
 }
 
-func (p *Proxy) handleEthSendBundle(req *RpcReq) *RpcResp {
+// sendBundle validates and forwards a bundle on behalf of addr, subject
+// to its pending cap
+func (p *Proxy) sendBundle(ctx context.Context, req *RpcReq, addr string) *RpcResp {
+	observer := p.observer()
+	observer.OnAccepted(addr, req)
+
+	// as the relay signed it, before any retarget
+	signed := *req
+	err := p.admitBundle(req)
+	if err != nil {
+		observer.OnDropped(addr, req, "invalidParams")
+		return invalidParams(req, err)
+	}
+
+	if !p.acquirePending(addr) {
+		p.logCapRejection(addr)
+		observer.OnDropped(addr, req, "pendingCap")
+		return &RpcResp{
+			"2.0",
+			nil,
+			&RpcErr{
+				-32005,
+				"Too many pending bundles",
+				nil,
+			},
+			req.Id,
+		}
+	}
+	defer p.releasePending(addr)
+
+	p.mirror(addr, &signed)
+
+	resp := p.handleEthSendBundle(ctx, req)
+	observer.OnDispatched(addr, req, resp)
+	return resp
+}
+
+func (p *Proxy) handleEthSendBundle(ctx context.Context, req *RpcReq) *RpcResp {
+	upstream := p.upstreamFor(req.Method)
 	// bundle RPC APIs now moved to the mev namespace
 	req.Method = "mev_sendBundle"
-	return makeRpcCall(req, p.RpcAddr)
+	start := time.Now()
+	resp := p.makeRpcCall(ctx, req, upstream)
+	deliveryLatency.observe(upstream, time.Since(start))
+	return resp
+}
+
+// isBundleMethod reports whether method submits, simulates or cancels
+// bundles. Those only reach the upstream through sendBundle, never
+// through passthrough, so they cannot skip bundle admission.
+func isBundleMethod(method string) bool {
+	return strings.HasPrefix(method, "mev_") ||
+		strings.HasSuffix(method, "Bundle") ||
+		strings.HasSuffix(method, "Bundles")
+}
+
+// withField returns an object result with key added, wrapping any other
+// result in an object. The given result is left untouched.
+func withField(result interface{}, key string, value interface{}) interface{} {
+	if obj, ok := result.(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(obj)+1)
+		for k, v := range obj {
+			copied[k] = v
+		}
+		copied[key] = value
+		return copied
+	}
+
+	return map[string]interface{}{
+		"result": result,
+		key:      value,
+	}
+}
+
+// NotFound is the body sent for requests outside SubmissionPaths
+type NotFound struct {
+	Error string   `json:"error"`
+	Paths []string `json:"paths"`
+}
+
+func (p *Proxy) isSubmissionPath(path string) bool {
+	for _, submissionPath := range p.SubmissionPaths {
+		if path == submissionPath {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Proxy) handleRpc(w http.ResponseWriter, r *http.Request) {
 	// Verify method and path
-	if r.Method != "POST" || r.URL.Path != "/" {
-		w.WriteHeader(404)
+	if r.Method != "POST" || !p.isSubmissionPath(r.URL.Path) {
+		writeJSON(w, 404, &NotFound{"Not found, POST JSON-RPC requests to one of the paths", p.SubmissionPaths})
+		return
+	}
+
+	// Count the request before checking for drain, so a drain status
+	// reporting zero never misses a request that got past the check
+	atomic.AddInt64(&p.inflight, 1)
+	defer atomic.AddInt64(&p.inflight, -1)
+	requestCounts.Add("received", 1)
+	if p.isDraining() {
+		rejections.Add("draining", 1)
+		w.WriteHeader(503)
+		w.Write([]byte("Draining"))
 		return
 	}
 
@@ -157,6 +624,7 @@ func (p *Proxy) handleRpc(w http.ResponseWriter, r *http.Request) {
 	if r.Header.Get("Content-Type") != "application/json" ||
 		err != nil ||
 		bodyLength == 0 {
+		rejections.Add("contentType", 1)
 		w.WriteHeader(400)
 		w.Write([]byte("Invalid content type"))
 		return
@@ -167,57 +635,136 @@ func (p *Proxy) handleRpc(w http.ResponseWriter, r *http.Request) {
 	var req *RpcReq = &RpcReq{}
 	err = decoder.Decode(req)
 	if err != nil || req.Jsonrpc != "2.0" {
+		rejections.Add("decode", 1)
 		w.WriteHeader(400)
 		w.Write([]byte("Request decode error"))
 		return
 	}
+	// Anything but whitespace after the request may be smuggled content
+	if p.StrictRequestBody {
+		if _, err := decoder.Token(); err != io.EOF {
+			rejections.Add("trailingData", 1)
+			w.WriteHeader(400)
+			w.Write([]byte("Trailing data after request"))
+			return
+		}
+	}
 
 	// Retrieve signature key
 	relaySigStr := r.Header.Get("X-Marlin-Signature")
 	// fmt.Println(relaySigStr)
-	relaySigBytes, err := hex.DecodeString(relaySigStr[2:])
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Signature decode error"))
-		return
-	}
 
-	hasher := sha3.NewLegacyKeccak256()
-	hasher.Write([]byte("\x19Bor Signed MEV TxBundle:\n"))
-	hasher.Write(req.Params)
-	msgHash := hasher.Sum(nil)
+	// In optional mode a present signature is still verified, so that the
+	// sender is known, while unsigned requests go through anonymously
+	verified := p.SignatureMode == "required" ||
+		(p.SignatureMode == "optional" && relaySigStr != "")
+	var pubkey []byte
+	addr := ""
+	if verified {
+		var sigErr *SigError
+		pubkey, addr, sigErr = recoverRelaySigner(relaySigStr, req.Params)
+		if sigErr != nil {
+			sigFailures.Add(sigErr.Kind, 1)
+			w.WriteHeader(400)
+			w.Write([]byte(sigErr.Message))
+			return
+		}
+		fmt.Println("Bundle received from ", p.formatAddress(addr))
+	}
 
-	pubkey, err := secp256k1.RecoverPubkey(msgHash, relaySigBytes)
-	if err != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("Signature recovery error"))
+	// Answered before the whitelist check, as it is for debugging mismatches
+	if p.DebugRecoverSigner && req.Method == "mev_recoverSigner" && verified {
+		writeJSON(w, 200, &RpcResp{
+			"2.0",
+			map[string]string{
+				"pubkey":  fmt.Sprintf("0x%x", pubkey),
+				"address": p.formatAddress(addr),
+			},
+			nil,
+			req.Id,
+		})
 		return
 	}
 
-	// Transform into address
-	hasher.Reset()
-	hasher.Write(pubkey[1:])
-	addrBytes := hasher.Sum(nil)[12:]
-	addr := fmt.Sprintf("0x%x", addrBytes)
-	fmt.Println("Bundle received from ", addr)
-
 	// Retrieve whitelist
 	whitelistPtr := atomic.LoadPointer(&p.Whitelist)
-	whitelist := (*[]string)(whitelistPtr)
+	whitelist := (*map[string]struct{})(whitelistPtr)
 
 	// fmt.Println("Whitelist: ", *whitelist)
 
-	// Verify whitelisted
-	idx := sort.SearchStrings(*whitelist, addr)
-	if (*whitelist)[idx] != addr {
+	// Verify whitelisted, only enforced when signatures are required
+	_, whitelisted := (*whitelist)[addr]
+	if p.SignatureMode == "required" && !whitelisted {
+		rejections.Add("notWhitelisted", 1)
 		w.WriteHeader(400)
+		if p.EchoSender {
+			w.Write([]byte("Sender not whitelisted: " + p.formatAddress(addr)))
+		}
 		return
 	}
 
+	// Without a recovered sender there is nobody to authorize
+	if p.Authorizer != nil && addr != "" {
+		ok, err := p.Authorizer.Authorize(r.Context(), addr, req)
+		if err != nil {
+			fmt.Println("Authorizer error for", p.formatAddress(addr), err)
+			rejections.Add("authorizerError", 1)
+			w.WriteHeader(503)
+			w.Write([]byte("Authorization unavailable"))
+			return
+		}
+		if !ok {
+			rejections.Add("notAuthorized", 1)
+			w.WriteHeader(400)
+			if p.EchoSender {
+				w.Write([]byte("Sender not authorized: " + p.formatAddress(addr)))
+			}
+			return
+		}
+	}
+
 	var resp *RpcResp
 	if req.Method == "eth_sendBundle" {
-		resp = p.handleEthSendBundle(req)
+		// receipts cover the params the relay signed, sendBundle may
+		// retarget the forwarded ones
+		signedParams := req.Params
+		send := func() *RpcResp {
+			requestCounts.Add("accepted", 1)
+			resp := p.sendBundle(r.Context(), req, addr)
+			if resp.Error != nil {
+				requestCounts.Add("failed", 1)
+				return resp
+			}
+
+			requestCounts.Add("dispatched", 1)
+			if p.SignReceipts {
+				receipt, err := p.receipt(signedParams)
+				if err != nil {
+					fmt.Println("receipt sign err", err)
+				} else {
+					resp.Result = withField(resp.Result, "receipt", receipt)
+				}
+			}
+			return resp
+		}
+
+		idemKey := r.Header.Get("Idempotency-Key")
+		// Keys are scoped by the recovered signer, so one signer cannot
+		// replay or hold up another's. Without a signer there is no scope,
+		// and the key is ignored.
+		if idemKey != "" && p.IdempotencyTTL > 0 && addr != "" {
+			var replayed bool
+			resp, replayed = p.idempotent(r.Context(), addr+"/"+idemKey, req, send)
+			if replayed {
+				requestCounts.Add("replayed", 1)
+			}
+		} else {
+			resp = send()
+		}
+	} else if p.PassthroughAll && !isBundleMethod(req.Method) {
+		resp = p.makeRpcCall(r.Context(), req, p.upstreamFor(req.Method))
 	} else {
+		rejections.Add("methodNotFound", 1)
 		resp = &RpcResp{
 			"2.0",
 			nil,
@@ -230,6 +777,10 @@ func (p *Proxy) handleRpc(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if p.EchoSender && resp.Error == nil {
+		resp.Result = withField(resp.Result, "sender", p.formatAddress(addr))
+	}
+
 	respBytes, err := json.Marshal(resp)
 	if err != nil {
 		w.WriteHeader(500)
@@ -243,31 +794,96 @@ func (p *Proxy) handleRpc(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func (p *Proxy) ListenAndServe(addr string) {
-	// spawn whitelist routine
-	atomic.StorePointer(&p.Whitelist, unsafe.Pointer(new([]string)))
-	go func() {
-		ticker := time.NewTicker(60 * time.Second)
-		for {
-			keys, err := p.fetchWhitelist()
-			if err != nil {
-				fmt.Println("whitelist fetch err", err)
-				<-ticker.C
-				continue
+// listen opens a TCP listener on addr, with SO_REUSEPORT if ReusePort is
+// set so that every listener can be handed over to a new process
+func (p *Proxy) listen(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if p.ReusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// refreshWhitelist fetches the whitelist every WhitelistRefreshInterval,
+// retrying failed fetches sooner with an exponential backoff
+func (p *Proxy) refreshWhitelist() {
+	ctx, cancel := p.stopContext()
+	defer cancel()
+
+	retryDelay := p.WhitelistRetryDelay
+	for {
+		keys, version, err := p.fetchWhitelist(ctx)
+		if err != nil {
+			fmt.Println("whitelist fetch err", err, "retrying in", retryDelay)
+			if !p.sleep(retryDelay) {
+				return
+			}
+			// back off exponentially, but never wait longer than a refresh
+			retryDelay *= 2
+			if retryDelay > p.WhitelistRefreshInterval {
+				retryDelay = p.WhitelistRefreshInterval
 			}
+			continue
+		}
+		retryDelay = p.WhitelistRetryDelay
 
-			sort.Strings(keys)
+		p.updateWhitelist(keys, version)
 
-			// fmt.Println(keys)
+		if !p.sleep(p.WhitelistRefreshInterval) {
+			return
+		}
+	}
+}
 
-			// storing pointer to slice here
-			atomic.StorePointer(&p.Whitelist, unsafe.Pointer(&keys))
+func (p *Proxy) ListenAndServe(addr string) {
+	p.listenAddr = addr
 
-			<-ticker.C
-		}
-	}()
+	if p.StatsPersistPath != "" {
+		p.persistStatsOnExit()
+	}
+
+	if p.DrainFile != "" {
+		p.spawn(p.watchDrainFile)
+	}
+
+	if p.HeadPollInterval > 0 {
+		p.spawn(p.pollHead)
+	}
+
+	if len(p.LatencyBuckets) > 0 {
+		deliveryLatency.setBuckets(p.LatencyBuckets)
+	}
 
-	http.HandleFunc("/", p.handleRpc)
+	if p.MirrorAddr != "" {
+		p.mirrorQueue = make(chan []byte, mirrorQueueSize)
+		p.spawn(p.runMirror)
+	}
+
+	// spawn whitelist routine
+	atomic.StorePointer(&p.Whitelist, unsafe.Pointer(&map[string]struct{}{}))
+	p.spawn(p.refreshWhitelist)
+
+	// Admin is kept off the public listener entirely
+	if p.AdminToken != "" && p.AdminAddr != "" {
+		go p.serveAdmin()
+	}
+	if p.MetricsAddr != "" {
+		go p.serveMetrics()
+	}
+
+	listener, err := p.listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if p.ListenBacklog > 0 {
+		err = setBacklog(listener, p.ListenBacklog)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if p.ConnRatePerIP > 0 {
+		listener = newConnLimitListener(listener, p.ConnRatePerIP, p.ConnBurstPerIP)
+	}
 
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(http.Serve(listener, p.publicMux()))
 }
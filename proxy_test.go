@@ -0,0 +1,694 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+// Throwaway relay keys, never used outside tests
+var (
+	testKey  = mustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	otherKey = mustDecodeHex("8da4ef21b864d2cc526dbdb2a120bd2874c36c9d0a1fb7f8c63d7f7a8b41de8f")
+)
+
+const testBundle = `[{"txs":["0x01"],"blockNumber":"0x10"}]`
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// relaySign signs params the way relays sign X-Marlin-Signature
+func relaySign(t *testing.T, key []byte, params string) string {
+	t.Helper()
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte("\x19Bor Signed MEV TxBundle:\n"))
+	hasher.Write([]byte(params))
+	sig, err := secp256k1.Sign(hasher.Sum(nil), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "0x" + hex.EncodeToString(sig)
+}
+
+// keySign signs keccak256(msg), as the proxy, whitelist and validator
+// signatures are made
+func keySign(t *testing.T, key []byte, msg []byte) string {
+	t.Helper()
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(msg)
+	sig, err := secp256k1.Sign(hasher.Sum(nil), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "0x" + hex.EncodeToString(sig)
+}
+
+// keyAddr returns the lowercase address of key
+func keyAddr(t *testing.T, key []byte) string {
+	t.Helper()
+	_, addr, sigErr := recoverRelaySigner(relaySign(t, key, "[]"), []byte("[]"))
+	if sigErr != nil {
+		t.Fatal(sigErr)
+	}
+	return addr
+}
+
+// newTestProxy returns a proxy with the flag defaults, forwarding to
+// upstream and whitelisting addrs
+func newTestProxy(upstream string, addrs ...string) *Proxy {
+	p := &Proxy{
+		RpcAddr:                  upstream,
+		SubmissionPaths:          []string{"/"},
+		SignatureMode:            "required",
+		AddressFormat:            "lowercase",
+		MaxWhitelistShrink:       50,
+		WhitelistRefreshInterval: time.Minute,
+		WhitelistRetryDelay:      time.Second,
+		DrainFileInterval:        5 * time.Second,
+		ConnBurstPerIP:           20,
+		IdempotencyCacheSize:     10000,
+		SubgraphPath:             "/marlinprotocol/mev-bor",
+	}
+	setWhitelist(p, addrs...)
+	return p
+}
+
+func setWhitelist(p *Proxy, addrs ...string) {
+	whitelist := make(map[string]struct{})
+	for _, addr := range addrs {
+		whitelist[addr] = struct{}{}
+	}
+	p.Whitelist = unsafe.Pointer(&whitelist)
+}
+
+// newUpstream serves JSON-RPC with handle, echoing the request id
+func newUpstream(t *testing.T, handle func(req *RpcReq) interface{}) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &RpcReq{}
+		json.NewDecoder(r.Body).Decode(req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&RpcResp{"2.0", handle(req), nil, req.Id})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// rpcRequest builds a submission of the raw body, with the relay
+// signature sig if it is not empty
+func rpcRequest(body string, sig string) *http.Request {
+	r := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(body)))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	if sig != "" {
+		r.Header.Set("X-Marlin-Signature", sig)
+	}
+	return r
+}
+
+// postRpc sends a JSON-RPC request through handleRpc, signed with key
+// unless it is nil
+func postRpc(t *testing.T, p *Proxy, method string, params string, key []byte, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `{"jsonrpc":"2.0","method":"` + method + `","params":` + params + `,"id":1}`
+	r := rpcRequest(body, "")
+	if key != nil {
+		r.Header.Set("X-Marlin-Signature", relaySign(t, key, params))
+	}
+	for idx := 0; idx+1 < len(headers); idx += 2 {
+		r.Header.Set(headers[idx], headers[idx+1])
+	}
+
+	w := httptest.NewRecorder()
+	p.handleRpc(w, r)
+	return w
+}
+
+// decodeResp decodes a JSON-RPC response, failing on any other reply
+func decodeResp(t *testing.T, w *httptest.ResponseRecorder) *RpcResp {
+	t.Helper()
+	if w.Code != 200 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	resp := &RpcResp{}
+	err := json.Unmarshal(w.Body.Bytes(), resp)
+	if err != nil {
+		t.Fatalf("decode %q: %s", w.Body.String(), err)
+	}
+	return resp
+}
+
+// intVar reads an expvar.Int, counters are global so tests compare deltas
+func intVar(name string) int64 {
+	return expvar.Get(name).(*expvar.Int).Value()
+}
+
+func readAll(t *testing.T, r *http.Response) string {
+	t.Helper()
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestWithFieldLeavesResultUntouched(t *testing.T) {
+	result := map[string]interface{}{"bundleHash": "0x01"}
+	got := withField(result, "sender", "0xabc").(map[string]interface{})
+
+	if _, ok := result["sender"]; ok {
+		t.Fatal("withField changed the given result")
+	}
+	if got["sender"] != "0xabc" || got["bundleHash"] != "0x01" {
+		t.Fatalf("got %v", got)
+	}
+
+	wrapped := withField("0x01", "sender", "0xabc").(map[string]interface{})
+	if wrapped["result"] != "0x01" || wrapped["sender"] != "0xabc" {
+		t.Fatalf("got %v", wrapped)
+	}
+}
+
+func TestPassthroughForwardsOtherMethods(t *testing.T) {
+	var forwarded []string
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarded = append(forwarded, req.Method)
+		return "0x10"
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.PassthroughAll = true
+
+	resp := decodeResp(t, postRpc(t, p, "eth_blockNumber", "[]", testKey))
+	if resp.Error != nil || resp.Result != "0x10" {
+		t.Fatalf("got %+v", resp)
+	}
+
+	for _, method := range []string{"mev_sendBundle", "mev_sendBundles", "eth_callBundle", "eth_cancelBundle"} {
+		resp := decodeResp(t, postRpc(t, p, method, testBundle, testKey))
+		if resp.Error == nil || resp.Error.Code != -32601 {
+			t.Errorf("%s: got %+v, want method not found", method, resp)
+		}
+	}
+
+	if len(forwarded) != 1 || forwarded[0] != "eth_blockNumber" {
+		t.Fatalf("forwarded %v", forwarded)
+	}
+}
+
+func TestPassthroughOffRejectsOtherMethods(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1", keyAddr(t, testKey))
+
+	resp := decodeResp(t, postRpc(t, p, "eth_blockNumber", "[]", testKey))
+	if resp.Error == nil || resp.Error.Code != -32601 {
+		t.Fatalf("got %+v, want method not found", resp)
+	}
+}
+
+func TestPendingCountersAreRemovedWhenIdle(t *testing.T) {
+	p := newTestProxy("")
+	p.MaxQueuedPerAddress = 2
+	p.CapLogInterval = 0
+
+	if !p.acquirePending("0xa") || !p.acquirePending("0xa") {
+		t.Fatal("acquire under the cap failed")
+	}
+	if p.acquirePending("0xa") {
+		t.Fatal("acquire over the cap succeeded")
+	}
+	p.releasePending("0xa")
+	p.releasePending("0xa")
+
+	if _, ok := p.pending.Load("0xa"); ok {
+		t.Fatal("idle signer still has a pending counter")
+	}
+	if !p.acquirePending("0xa") {
+		t.Fatal("acquire after removal failed")
+	}
+	p.releasePending("0xa")
+}
+
+func TestPendingCapHoldsUnderConcurrency(t *testing.T) {
+	p := newTestProxy("")
+	p.MaxQueuedPerAddress = 3
+
+	var held, maxHeld int64
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				if !p.acquirePending("0xa") {
+					continue
+				}
+				n := atomic.AddInt64(&held, 1)
+				for {
+					m := atomic.LoadInt64(&maxHeld)
+					if n <= m || atomic.CompareAndSwapInt64(&maxHeld, m, n) {
+						break
+					}
+				}
+				atomic.AddInt64(&held, -1)
+				p.releasePending("0xa")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxHeld > 3 {
+		t.Fatalf("%d slots held at once, cap is 3", maxHeld)
+	}
+	if _, ok := p.pending.Load("0xa"); ok {
+		t.Fatal("pending counter left behind")
+	}
+}
+
+func TestCapRejectionsOfUnlistedSendersShareAKey(t *testing.T) {
+	p := newTestProxy("", "0xa")
+
+	if key := p.capRejectionKey("0xa"); key != "0xa" {
+		t.Fatalf("whitelisted sender keyed %q", key)
+	}
+	if key := p.capRejectionKey("0xb"); key != "unlisted" {
+		t.Fatalf("unlisted sender keyed %q", key)
+	}
+}
+
+// newRawUpstream answers every request with the raw HTTP response resp and
+// then closes the connection
+func newRawUpstream(t *testing.T, resp string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf.WriteString(resp)
+		buf.Flush()
+		conn.Close()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestUpstreamConnectionDropAndMalformedResponses(t *testing.T) {
+	cases := []struct {
+		name string
+		resp string
+		want string
+	}{
+		{
+			"closed mid-body",
+			"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"jsonrpc\":\"2.0\",",
+			"Upstream connection closed",
+		},
+		{
+			"empty body",
+			"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 0\r\n\r\n",
+			"Upstream response error",
+		},
+		{
+			"truncated but complete body",
+			"HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: 17\r\n\r\n{\"jsonrpc\":\"2.0\",",
+			"Upstream response error",
+		},
+	}
+	for _, c := range cases {
+		p := newTestProxy(newRawUpstream(t, c.resp).URL)
+		resp := p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_blockNumber", json.RawMessage("[]"), json.RawMessage("1")}, p.RpcAddr)
+		if resp.Error == nil || resp.Error.Message != c.want {
+			t.Errorf("%s: got %+v, want %q", c.name, resp.Error, c.want)
+		}
+	}
+}
+
+func TestSameId(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{`1`, `1`, true},
+		{`"a"`, ` "a" `, true},
+		{`{"x": 1}`, `{"x":1}`, true},
+		{``, `null`, true},
+		{`null`, ``, true},
+		{``, ``, true},
+		{`1`, `2`, false},
+		{`1`, `"1"`, false},
+		{``, `0`, false},
+	}
+	for _, c := range cases {
+		if got := sameId(json.RawMessage(c.a), json.RawMessage(c.b)); got != c.want {
+			t.Errorf("sameId(%q, %q) = %v", c.a, c.b, got)
+		}
+	}
+}
+
+func TestUpstreamIdMismatch(t *testing.T) {
+	id := `7`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","result":"0x1","id":` + id + `}`))
+	}))
+	defer upstream.Close()
+	p := newTestProxy(upstream.URL)
+
+	before := intVar("upstreamIdMismatches")
+	resp := p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_blockNumber", json.RawMessage("[]"), json.RawMessage("1")}, p.RpcAddr)
+	if resp.Error == nil || resp.Error.Message != "Upstream response id mismatch" || string(resp.Id) != "1" {
+		t.Fatalf("got %+v", resp)
+	}
+	if intVar("upstreamIdMismatches") != before+1 {
+		t.Fatal("mismatch not counted")
+	}
+
+	// a request without an id answered with "id": null
+	id = `null`
+	resp = p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_blockNumber", json.RawMessage("[]"), nil}, p.RpcAddr)
+	if resp.Error != nil {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	if intVar("upstreamIdMismatches") != before+1 {
+		t.Fatal("null id counted as a mismatch")
+	}
+}
+
+func TestEchoSender(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.EchoSender = true
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	result, ok := resp.Result.(map[string]interface{})
+	if resp.Error != nil || !ok || result["sender"] != keyAddr(t, testKey) || result["bundleHash"] != "0x01" {
+		t.Fatalf("got %+v", resp)
+	}
+
+	w := postRpc(t, p, "eth_sendBundle", testBundle, otherKey)
+	if w.Code != 400 || w.Body.String() != "Sender not whitelisted: "+keyAddr(t, otherKey) {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	p.EchoSender = false
+	resp = decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if _, ok := resp.Result.(map[string]interface{})["sender"]; ok {
+		t.Fatal("sender echoed with echoSender off")
+	}
+	w = postRpc(t, p, "eth_sendBundle", testBundle, otherKey)
+	if w.Code != 400 || w.Body.Len() != 0 {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpstreamErrorsWithContentTypeParameters(t *testing.T) {
+	for _, contentType := range []string{"application/json", "application/json; charset=utf-8", "text/html"} {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(`{"jsonrpc":"2.0","error":{"code":-32000,"message":"bundle too late"},"id":1}`))
+		}))
+		p := newTestProxy(upstream.URL)
+
+		resp := p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_sendBundle", json.RawMessage(testBundle), json.RawMessage("1")}, p.RpcAddr)
+		want := "bundle too late"
+		if contentType == "text/html" {
+			want = "Upstream response error"
+		}
+		if resp.Error == nil || resp.Error.Message != want {
+			t.Errorf("%s: got %+v, want %q", contentType, resp.Error, want)
+		}
+		upstream.Close()
+	}
+}
+
+func TestPendingCapRejectsExtraBundles(t *testing.T) {
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		arrived <- struct{}{}
+		<-release
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.MaxQueuedPerAddress = 1
+
+	done := make(chan *RpcResp)
+	go func() {
+		w := postRpc(t, p, "eth_sendBundle", testBundle, testKey)
+		resp := &RpcResp{}
+		json.Unmarshal(w.Body.Bytes(), resp)
+		done <- resp
+	}()
+	<-arrived
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error == nil || resp.Error.Code != -32005 {
+		t.Fatalf("got %+v, want too many pending bundles", resp)
+	}
+
+	close(release)
+	if resp := <-done; resp.Error != nil {
+		t.Fatalf("first bundle got %+v", resp.Error)
+	}
+
+	// the slot is free again
+	go func() { <-arrived }()
+	resp = decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error != nil {
+		t.Fatalf("got %+v after the first bundle finished", resp.Error)
+	}
+}
+
+func TestIdsEchoedVerbatim(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+
+	for _, id := range []string{`1`, `"abc"`, `18446744073709551617`, `1.50`, `null`} {
+		body := `{"jsonrpc":"2.0","method":"eth_sendBundle","params":` + testBundle + `,"id":` + id + `}`
+		r := rpcRequest(body, relaySign(t, testKey, testBundle))
+		w := httptest.NewRecorder()
+		p.handleRpc(w, r)
+
+		resp := decodeResp(t, w)
+		if resp.Error != nil || string(resp.Id) != id {
+			t.Errorf("id %s: got %s %+v", id, resp.Id, resp.Error)
+		}
+	}
+}
+
+func TestMethodRoutes(t *testing.T) {
+	var bundles, others []string
+	bundleUpstream := newUpstream(t, func(req *RpcReq) interface{} {
+		bundles = append(bundles, req.Method)
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	defaultUpstream := newUpstream(t, func(req *RpcReq) interface{} {
+		others = append(others, req.Method)
+		return "0x10"
+	})
+	p := newTestProxy(defaultUpstream.URL, keyAddr(t, testKey))
+	p.PassthroughAll = true
+	// routed by the method clients call, not the one forwarded
+	p.MethodRoutes = map[string]string{"eth_sendBundle": bundleUpstream.URL}
+
+	decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	decodeResp(t, postRpc(t, p, "eth_blockNumber", "[]", testKey))
+
+	if len(bundles) != 1 || bundles[0] != "mev_sendBundle" {
+		t.Fatalf("bundle upstream got %v", bundles)
+	}
+	if len(others) != 1 || others[0] != "eth_blockNumber" {
+		t.Fatalf("default upstream got %v", others)
+	}
+}
+
+func TestCapRejectionsCountedAndLogThrottled(t *testing.T) {
+	p := newTestProxy("", "0xa")
+	p.CapLogInterval = time.Hour
+
+	counted := func(key string) int64 {
+		if v, ok := pendingCapRejections.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	listed, unlisted := counted("0xa"), counted("unlisted")
+
+	p.logCapRejection("0xa")
+	v, ok := p.capLogged.Load("0xa")
+	if !ok {
+		t.Fatal("first rejection not logged")
+	}
+	logged := atomic.LoadInt64(v.(*int64))
+	p.logCapRejection("0xa")
+	if atomic.LoadInt64(v.(*int64)) != logged {
+		t.Fatal("logged again within capLogInterval")
+	}
+	p.logCapRejection("0xb")
+	p.logCapRejection("0xc")
+
+	if counted("0xa") != listed+2 || counted("unlisted") != unlisted+2 {
+		t.Fatalf("got %d for 0xa and %d unlisted", counted("0xa")-listed, counted("unlisted")-unlisted)
+	}
+}
+
+func TestUnreachableUpstream(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1", keyAddr(t, testKey))
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error == nil || resp.Error.Code != -32003 || resp.Error.Message != "Upstream unavailable" {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	if string(resp.Id) != "1" {
+		t.Fatalf("got id %s", resp.Id)
+	}
+}
+
+func TestUpstreamBodyLimit(t *testing.T) {
+	calls := 0
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		calls++
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	reqBytes, _ := json.Marshal(&RpcReq{"2.0", "mev_sendBundle", json.RawMessage(testBundle), json.RawMessage("1")})
+
+	p.MaxUpstreamBodyBytes = len(reqBytes)
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error != nil {
+		t.Fatalf("request at the limit got %+v", resp.Error)
+	}
+
+	p.MaxUpstreamBodyBytes = len(reqBytes) - 1
+	resp = decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("request over the limit got %+v", resp)
+	}
+	if calls != 1 {
+		t.Fatalf("upstream called %d times", calls)
+	}
+}
+
+func TestUpstreamCallAbortedWithClient(t *testing.T) {
+	arrived := make(chan struct{})
+	aborted := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server only notices the client going once the body is read
+		ioutil.ReadAll(r.Body)
+		close(arrived)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer upstream.Close()
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-arrived
+		cancel()
+	}()
+	body := `{"jsonrpc":"2.0","method":"eth_sendBundle","params":` + testBundle + `,"id":1}`
+	r := rpcRequest(body, relaySign(t, testKey, testBundle)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	p.handleRpc(w, r)
+
+	resp := decodeResp(t, w)
+	if resp.Error == nil || resp.Error.Code != -32003 {
+		t.Fatalf("got %+v", resp)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not aborted")
+	}
+}
+
+func TestStrictRequestBody(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+
+	for _, c := range []struct {
+		trailer string
+		strict  bool
+		code    int
+	}{
+		{"", true, 200},
+		{" \n\t", true, 200},
+		{`{"jsonrpc":"2.0","method":"eth_sendBundle"}`, true, 400},
+		{"x", true, 400},
+		{`{"jsonrpc":"2.0","method":"eth_sendBundle"}`, false, 200},
+	} {
+		p.StrictRequestBody = c.strict
+		body := `{"jsonrpc":"2.0","method":"eth_sendBundle","params":` + testBundle + `,"id":1}` + c.trailer
+		r := rpcRequest(body, relaySign(t, testKey, testBundle))
+		w := httptest.NewRecorder()
+		p.handleRpc(w, r)
+
+		if w.Code != c.code {
+			t.Errorf("trailer %q strict=%v: status %d, want %d", c.trailer, c.strict, w.Code, c.code)
+		}
+	}
+}
+
+func TestSubmissionPaths(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.SubmissionPaths = []string{"/", "/bundle"}
+	body := `{"jsonrpc":"2.0","method":"eth_sendBundle","params":` + testBundle + `,"id":1}`
+
+	for _, path := range []string{"/", "/bundle"} {
+		r := rpcRequest(body, relaySign(t, testKey, testBundle))
+		r.URL.Path = path
+		w := httptest.NewRecorder()
+		p.handleRpc(w, r)
+		if resp := decodeResp(t, w); resp.Error != nil {
+			t.Errorf("%s: got %+v", path, resp.Error)
+		}
+	}
+
+	for _, c := range []struct{ method, path string }{{"POST", "/other"}, {"GET", "/"}, {"POST", "/bundle/"}} {
+		r := rpcRequest(body, relaySign(t, testKey, testBundle))
+		r.Method = c.method
+		r.URL.Path = c.path
+		w := httptest.NewRecorder()
+		p.handleRpc(w, r)
+
+		notFound := &NotFound{}
+		err := json.Unmarshal(w.Body.Bytes(), notFound)
+		if w.Code != 404 || err != nil || w.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s %s: status %d %s", c.method, c.path, w.Code, w.Body.String())
+			continue
+		}
+		if len(notFound.Paths) != 2 || notFound.Paths[1] != "/bundle" {
+			t.Errorf("%s %s: paths %v", c.method, c.path, notFound.Paths)
+		}
+	}
+}
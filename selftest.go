@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SelfTest runs each startup check once, printing a line per check.
+// Returns false if any check failed.
+func (p *Proxy) SelfTest() bool {
+	ok := true
+
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("[FAIL] %s: %s\n", name, err)
+			ok = false
+			return
+		}
+		fmt.Printf("[ OK ] %s\n", name)
+	}
+
+	// Whitelist source reachable and returning a decodable whitelist
+	keys, _, err := p.fetchWhitelist(context.Background())
+	if err == nil && len(keys) == 0 {
+		err = fmt.Errorf("whitelist is empty")
+	}
+	report("whitelist", err)
+
+	// Upstream reachable and speaking JSON-RPC
+	resp := p.makeRpcCall(context.Background(), &RpcReq{
+		"2.0",
+		"eth_blockNumber",
+		json.RawMessage("[]"),
+		json.RawMessage("1"),
+	}, p.RpcAddr)
+	err = nil
+	if resp.Error != nil {
+		err = fmt.Errorf("%s", resp.Error.Message)
+	}
+	report("upstream", err)
+
+	// Signing key usable, if configured
+	if p.SigningKey != nil {
+		_, err = p.sign([]byte("self test"))
+		report("signing key", err)
+	}
+
+	return ok
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSelfTestHealthy(t *testing.T) {
+	redirectDefaultClient(t, newSubgraph(t, false, "0x0000000000000000000000000000000000000001"))
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return "0x10"
+	})
+	p := newTestProxy(upstream.URL)
+	p.SigningKey = otherKey
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+
+	if !p.SelfTest() {
+		t.Fatal("self test failed against healthy stubs")
+	}
+}
+
+func TestSelfTestUnhealthy(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return "0x10"
+	})
+
+	// empty whitelist
+	redirectDefaultClient(t, newSubgraph(t, false))
+	if newTestProxy(upstream.URL).SelfTest() {
+		t.Fatal("self test passed with an empty whitelist")
+	}
+
+	// unreachable upstream
+	redirectDefaultClient(t, newSubgraph(t, false, "0x0000000000000000000000000000000000000001"))
+	if newTestProxy("http://127.0.0.1:1").SelfTest() {
+		t.Fatal("self test passed with an unreachable upstream")
+	}
+
+	// unusable signing key
+	p := newTestProxy(upstream.URL)
+	p.SigningKey = make([]byte, 32)
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+	if p.SelfTest() {
+		t.Fatal("self test passed with an invalid signing key")
+	}
+}
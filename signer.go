@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+// loadSigningKey reads a hex encoded secp256k1 private key from path
+func loadSigningKey(path string) ([]byte, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyStr := strings.TrimPrefix(strings.TrimSpace(string(keyBytes)), "0x")
+	key, err := hex.DecodeString(keyStr)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("signing key %s: expected 32 hex encoded bytes", path)
+	}
+
+	// Sign rejects keys outside the curve order
+	_, err = secp256k1.Sign(make([]byte, 32), key)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %s", path, err)
+	}
+
+	return key, nil
+}
+
+// sign returns the 0x prefixed [R || S || V] signature of SigningKey over
+// keccak256(body), with V as the 0/1 recovery id like relay signatures
+func (p *Proxy) sign(body []byte) (string, error) {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(body)
+
+	sig, err := secp256k1.Sign(hasher.Sum(nil), p.SigningKey)
+	if err != nil {
+		return "", err
+	}
+
+	return "0x" + hex.EncodeToString(sig), nil
+}
+
+// checksumAddress returns the EIP-55 mixed case form of a lowercase
+// 0x prefixed address
+func checksumAddress(addr string) string {
+	hex := []byte(addr[2:])
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(hex)
+	hash := hasher.Sum(nil)
+
+	for i, c := range hex {
+		// uppercase letters whose hash nibble is 8 or more
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if c >= 'a' && c <= 'f' && nibble&0xf >= 8 {
+			hex[i] = c - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(hex)
+}
+
+// formatAddress renders a lowercase address for logs, responses and stats
+// in the configured AddressFormat. Comparisons always use lowercase.
+func (p *Proxy) formatAddress(addr string) string {
+	if p.AddressFormat == "checksum" && addr != "" {
+		return checksumAddress(addr)
+	}
+	return addr
+}
+
+type Receipt struct {
+	// keccak256 of the signed bundle params
+	BundleHash string `json:"bundleHash"`
+	Timestamp  int64  `json:"timestamp"`
+	// proxy signature over keccak256(bundleHash || 8 byte big endian timestamp)
+	Signature string `json:"signature"`
+}
+
+// receipt signs proof that the proxy accepted a bundle at this time
+func (p *Proxy) receipt(params []byte) (*Receipt, error) {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(params)
+	bundleHash := hasher.Sum(nil)
+
+	timestamp := time.Now().Unix()
+	msg := make([]byte, len(bundleHash)+8)
+	copy(msg, bundleHash)
+	binary.BigEndian.PutUint64(msg[len(bundleHash):], uint64(timestamp))
+
+	sig, err := p.sign(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Receipt{"0x" + hex.EncodeToString(bundleHash), timestamp, sig}, nil
+}
+
+// SigError is a relay signature failure, Kind labels sigFailures
+type SigError struct {
+	Kind    string
+	Message string
+}
+
+func (e *SigError) Error() string {
+	return e.Message
+}
+
+// recoverRelaySigner recovers the public key and lowercase address that
+// signed params, from a 0x prefixed X-Marlin-Signature value
+func recoverRelaySigner(relaySigStr string, params []byte) ([]byte, string, *SigError) {
+	if !strings.HasPrefix(relaySigStr, "0x") {
+		return nil, "", &SigError{"missing", "Signature missing"}
+	}
+	relaySigBytes, err := hex.DecodeString(relaySigStr[2:])
+	if err != nil {
+		return nil, "", &SigError{"decode", "Signature decode error"}
+	}
+	// 65 byte [R || S || V] with V as the 0/1 recovery id
+	if len(relaySigBytes) != 65 {
+		return nil, "", &SigError{"length", "Signature length error"}
+	}
+	if relaySigBytes[64] > 1 {
+		return nil, "", &SigError{"v", "Signature recovery id error"}
+	}
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte("\x19Bor Signed MEV TxBundle:\n"))
+	hasher.Write(params)
+	msgHash := hasher.Sum(nil)
+
+	pubkey, err := secp256k1.RecoverPubkey(msgHash, relaySigBytes)
+	if err != nil {
+		return nil, "", &SigError{"recovery", "Signature recovery error"}
+	}
+
+	// Transform into address
+	hasher.Reset()
+	hasher.Write(pubkey[1:])
+	addrBytes := hasher.Sum(nil)[12:]
+	return pubkey, fmt.Sprintf("0x%x", addrBytes), nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+func TestReceiptCoversSignedParamsAfterRetarget(t *testing.T) {
+	var forwarded string
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		forwarded = string(req.Params)
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.SigningKey = otherKey
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+	p.SignReceipts = true
+	p.StaleBlockPolicy = "retarget"
+	p.MirrorAddr = "http://mirror.invalid"
+	p.mirrorQueue = make(chan []byte, 1)
+	atomic.StoreUint64(&p.head, 0x10)
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+
+	args, err := parseBundleArgs([]byte(forwarded))
+	if err != nil || string(args.BlockNumber) != `"0x11"` {
+		t.Fatalf("forwarded %s, want it retargeted to 0x11", forwarded)
+	}
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(testBundle))
+	want := "0x" + hex.EncodeToString(hasher.Sum(nil))
+	receipt := resp.Result.(map[string]interface{})["receipt"].(map[string]interface{})
+	if receipt["bundleHash"] != want {
+		t.Fatalf("receipt bundleHash %v, want the hash of the signed params %s", receipt["bundleHash"], want)
+	}
+
+	mirrored := &MirroredBundle{}
+	json.Unmarshal(<-p.mirrorQueue, mirrored)
+	if string(mirrored.Request.Params) != testBundle || mirrored.Request.Method != "eth_sendBundle" {
+		t.Fatalf("mirrored %s %s, want the request as signed", mirrored.Request.Method, mirrored.Request.Params)
+	}
+}
+
+// signerOf recovers the address that made sig over keccak256(msg), as
+// made by sign, or "" if it cannot be recovered
+func signerOf(msg []byte, sig string) string {
+	sigBytes, _ := hex.DecodeString(strings.TrimPrefix(sig, "0x"))
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(msg)
+	pubkey, err := secp256k1.RecoverPubkey(hasher.Sum(nil), sigBytes)
+	if err != nil {
+		return ""
+	}
+	hasher.Reset()
+	hasher.Write(pubkey[1:])
+	return fmt.Sprintf("0x%x", hasher.Sum(nil)[12:])
+}
+
+func TestForwardedRequestsAreSigned(t *testing.T) {
+	var signer string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signer = signerOf(body, r.Header.Get("X-Marlin-Proxy-Signature"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","result":"0x1","id":1}`))
+	}))
+	defer upstream.Close()
+
+	p := newTestProxy(upstream.URL)
+	p.SigningKey = otherKey
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+	resp := p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_blockNumber", json.RawMessage("[]"), json.RawMessage("1")}, p.RpcAddr)
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+	if signer != keyAddr(t, otherKey) {
+		t.Fatalf("upstream recovered %q, want the proxy key's address", signer)
+	}
+}
+
+func TestSigningFailureIsNotAnUpstreamOutage(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		t.Error("unsigned request forwarded")
+		return nil
+	})
+	p := newTestProxy(upstream.URL)
+	// zero is not a valid secp256k1 private key
+	p.SigningKey = make([]byte, 32)
+	p.SignatureHeader = "X-Marlin-Proxy-Signature"
+
+	before := intVar("signingFailures")
+	resp := p.makeRpcCall(context.Background(), &RpcReq{"2.0", "eth_blockNumber", json.RawMessage("[]"), json.RawMessage("1")}, p.RpcAddr)
+	if resp.Error == nil || resp.Error.Code != -32603 || resp.Error.Message != "Request signing failed" {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	if intVar("signingFailures") != before+1 {
+		t.Fatal("signingFailures not counted")
+	}
+}
+
+func TestSignatureFailuresCountedByKind(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1", keyAddr(t, testKey))
+	valid := relaySign(t, testKey, testBundle)
+
+	cases := []struct {
+		sig  string
+		kind string
+	}{
+		{"", "missing"},
+		{valid[2:], "missing"},
+		{"0xzz" + valid[4:], "decode"},
+		{valid[:len(valid)-2], "length"},
+		{valid[:len(valid)-2] + "1b", "v"},
+		{"0x" + strings.Repeat("00", 65), "recovery"},
+	}
+	for _, c := range cases {
+		before := int64(0)
+		if v, ok := sigFailures.Get(c.kind).(*expvar.Int); ok {
+			before = v.Value()
+		}
+
+		w := postRpc(t, p, "eth_sendBundle", testBundle, nil, "X-Marlin-Signature", c.sig)
+		if w.Code != 400 {
+			t.Errorf("%s: status %d", c.kind, w.Code)
+		}
+		if v, ok := sigFailures.Get(c.kind).(*expvar.Int); !ok || v.Value() != before+1 {
+			t.Errorf("%q not counted as %s", c.sig, c.kind)
+		}
+	}
+}
+
+func TestDebugRecoverSigner(t *testing.T) {
+	p := newTestProxy("http://127.0.0.1:1")
+	params := `[{"any":"thing"}]`
+
+	// off by default, and answered before the whitelist check when on
+	w := postRpc(t, p, "mev_recoverSigner", params, otherKey)
+	if w.Code != 400 {
+		t.Fatalf("disabled: status %d", w.Code)
+	}
+
+	p.DebugRecoverSigner = true
+	p.AddressFormat = "checksum"
+	resp := decodeResp(t, postRpc(t, p, "mev_recoverSigner", params, otherKey))
+	result, ok := resp.Result.(map[string]interface{})
+	if resp.Error != nil || !ok {
+		t.Fatalf("got %+v", resp)
+	}
+	if result["address"] != p.formatAddress(keyAddr(t, otherKey)) || !strings.HasPrefix(result["pubkey"].(string), "0x04") {
+		t.Fatalf("got %v", result)
+	}
+
+	w = postRpc(t, p, "mev_recoverSigner", params, nil)
+	if w.Code != 400 || w.Body.String() != "Signature missing" {
+		t.Fatalf("unsigned: status %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	// EIP-55 test vectors
+	for _, want := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		if got := checksumAddress(strings.ToLower(want)); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+
+	p := newTestProxy("")
+	if got := p.formatAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"); got != "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed" {
+		t.Fatalf("lowercase format gave %s", got)
+	}
+	p.AddressFormat = "checksum"
+	if got := p.formatAddress(""); got != "" {
+		t.Fatalf("empty address formatted as %q", got)
+	}
+}
+
+func TestChecksumFormatStillMatchesWhitelist(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+	p.AddressFormat = "checksum"
+	p.EchoSender = true
+
+	resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, testKey))
+	if resp.Error != nil {
+		t.Fatalf("got %+v", resp.Error)
+	}
+	if sender := resp.Result.(map[string]interface{})["sender"]; sender != checksumAddress(keyAddr(t, testKey)) {
+		t.Fatalf("sender echoed as %v", sender)
+	}
+}
+
+func TestReceiptSignature(t *testing.T) {
+	p := newTestProxy("")
+	p.SigningKey = otherKey
+
+	receipt, err := p.receipt([]byte(testBundle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now := time.Now().Unix(); receipt.Timestamp < now-5 || receipt.Timestamp > now {
+		t.Fatalf("receipt timestamp %d", receipt.Timestamp)
+	}
+
+	msg := mustDecodeHex(strings.TrimPrefix(receipt.BundleHash, "0x"))
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(receipt.Timestamp))
+	msg = append(msg, timestamp...)
+	if signerOf(msg, receipt.Signature) != keyAddr(t, otherKey) {
+		t.Fatal("receipt not signed by the proxy key over bundleHash and timestamp")
+	}
+}
+
+func TestSignatureModes(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	bad := "0x" + strings.Repeat("00", 65)
+
+	cases := []struct {
+		mode string
+		key  []byte
+		sig  string
+		code int
+	}{
+		{"off", nil, "", 200},
+		{"off", nil, bad, 200},
+		{"optional", nil, "", 200},
+		{"optional", otherKey, "", 200},
+		{"optional", nil, bad, 400},
+		{"required", nil, "", 400},
+		{"required", otherKey, "", 400},
+		{"required", testKey, "", 200},
+	}
+	for _, c := range cases {
+		p := newTestProxy(upstream.URL, keyAddr(t, testKey))
+		p.SignatureMode = c.mode
+		var headers []string
+		if c.sig != "" {
+			headers = []string{"X-Marlin-Signature", c.sig}
+		}
+
+		w := postRpc(t, p, "eth_sendBundle", testBundle, c.key, headers...)
+		if w.Code != c.code {
+			t.Errorf("%s with key %v sig %q: status %d, want %d", c.mode, c.key != nil, c.sig, w.Code, c.code)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+// verifyValidatorAck checks that a bundle delivery response was signed by
+// ValidatorAddress. sigStr is the 0x prefixed [R || S || V] signature from
+// ValidatorSignatureHeader over keccak256 of the exact response body.
+// The returned kind labels validatorAckFailures.
+func (p *Proxy) verifyValidatorAck(sigStr string, body []byte) (string, error) {
+	if sigStr == "" {
+		return "missing", fmt.Errorf("acknowledgment unsigned")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(sigStr, "0x"))
+	if err != nil || len(sig) != 65 || sig[64] > 1 {
+		return "malformed", fmt.Errorf("acknowledgment signature malformed")
+	}
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(body)
+	pubkey, err := secp256k1.RecoverPubkey(hasher.Sum(nil), sig)
+	if err != nil {
+		return "recovery", fmt.Errorf("acknowledgment signature recovery error")
+	}
+
+	hasher.Reset()
+	hasher.Write(pubkey[1:])
+	signer := fmt.Sprintf("0x%x", hasher.Sum(nil)[12:])
+	if signer != strings.ToLower(p.ValidatorAddress) {
+		return "signer", fmt.Errorf("acknowledgment signed by %s, expected %s", signer, p.ValidatorAddress)
+	}
+
+	return "", nil
+}
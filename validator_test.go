@@ -0,0 +1,82 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newValidator answers bundle deliveries signed by key, or unsigned if
+// key is nil
+func newValidator(t *testing.T, key []byte) *httptest.Server {
+	t.Helper()
+	body := []byte(`{"jsonrpc":"2.0","result":{"bundleHash":"0x01"},"id":1}`)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if key != nil {
+			w.Header().Set("X-Validator-Signature", keySign(t, key, body))
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestValidatorAck(t *testing.T) {
+	cases := []struct {
+		name string
+		key  []byte
+		kind string
+	}{
+		{"signed by the validator", testKey, ""},
+		{"signed by another key", otherKey, "signer"},
+		{"unsigned", nil, "missing"},
+	}
+	for _, c := range cases {
+		p := newTestProxy(newValidator(t, c.key).URL, keyAddr(t, otherKey))
+		p.ValidatorAddress = checksumAddress(keyAddr(t, testKey))
+		p.ValidatorSignatureHeader = "X-Validator-Signature"
+
+		before := int64(0)
+		if v, ok := validatorAckFailures.Get(c.kind).(*expvar.Int); ok {
+			before = v.Value()
+		}
+		resp := decodeResp(t, postRpc(t, p, "eth_sendBundle", testBundle, otherKey))
+		if c.kind == "" {
+			if resp.Error != nil {
+				t.Errorf("%s: got %+v", c.name, resp.Error)
+			}
+			continue
+		}
+		if resp.Error == nil || resp.Error.Message != "Upstream acknowledgment not signed by the validator" {
+			t.Errorf("%s: got %+v", c.name, resp)
+		}
+		if v, ok := validatorAckFailures.Get(c.kind).(*expvar.Int); !ok || v.Value() != before+1 {
+			t.Errorf("%s: not counted as %s", c.name, c.kind)
+		}
+	}
+}
+
+func TestValidatorAckOnlyForBundles(t *testing.T) {
+	p := newTestProxy(newValidator(t, nil).URL, keyAddr(t, otherKey))
+	p.ValidatorAddress = keyAddr(t, testKey)
+	p.ValidatorSignatureHeader = "X-Validator-Signature"
+	p.PassthroughAll = true
+
+	resp := decodeResp(t, postRpc(t, p, "eth_blockNumber", "[]", otherKey))
+	if resp.Error != nil {
+		t.Fatalf("got %+v", resp.Error)
+	}
+}
+
+func TestVerifyValidatorAckMalformed(t *testing.T) {
+	p := newTestProxy("")
+	p.ValidatorAddress = keyAddr(t, testKey)
+
+	for _, sig := range []string{"0xzz", "0x00", keySign(t, testKey, []byte("x"))[:130] + "05"} {
+		if kind, err := p.verifyValidatorAck(sig, []byte("x")); kind != "malformed" || err == nil {
+			t.Errorf("%s: got %s %v", sig, kind, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/crypto/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+// shrinkAllowed reports whether the whitelist may be replaced by one of
+// size next. A drop of more than MaxWhitelistShrink percent is refused,
+// keeping the last good list, unless override is set by an admin.
+func (p *Proxy) shrinkAllowed(prev int, next int, override bool) bool {
+	if prev == 0 || next >= prev || p.MaxWhitelistShrink >= 100 {
+		return true
+	}
+
+	shrink := float64(prev-next) * 100 / float64(prev)
+	if shrink <= p.MaxWhitelistShrink {
+		return true
+	}
+
+	if override {
+		fmt.Printf("WARN: whitelist shrank %.1f%% (%d -> %d), accepted by admin override\n", shrink, prev, next)
+		return true
+	}
+
+	whitelistShrinkRejections.Add(1)
+	fmt.Printf("WARN: whitelist shrank %.1f%% (%d -> %d), over the %.1f%% limit, keeping last good whitelist\n",
+		shrink, prev, next, p.MaxWhitelistShrink)
+	return false
+}
+
+// updateWhitelist replaces the whitelist with a freshly fetched one, unless
+// the shrink guard refuses it
+func (p *Proxy) updateWhitelist(keys []string, version uint64) bool {
+	override := atomic.SwapInt32(&p.allowShrink, 0) == 1
+	current := (*map[string]struct{})(atomic.LoadPointer(&p.Whitelist))
+	if !p.shrinkAllowed(len(*current), len(keys), override) {
+		return false
+	}
+
+	whitelist := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		whitelist[key] = struct{}{}
+	}
+
+	// storing pointer to map here
+	atomic.StorePointer(&p.Whitelist, unsafe.Pointer(&whitelist))
+	atomic.StoreUint64(&p.whitelistVersion, version)
+	atomic.StoreInt64(&p.whitelistUpdated, time.Now().UnixNano())
+	return true
+}
+
+// handleAllowShrink lets the next successful whitelist fetch through the
+// shrink guard. The override is used up by that fetch whether or not it
+// shrank, so it cannot linger and wave through a much later shrink.
+func (p *Proxy) handleAllowShrink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(404)
+		return
+	}
+
+	atomic.StoreInt32(&p.allowShrink, 1)
+	w.WriteHeader(204)
+}
+
+// SignedWhitelist is a published whitelist file. Version must increase
+// with every file published, a unix timestamp will do. Signature is a 65
+// byte [R || S || V] signature by WhitelistSigner over keccak256 of the 8
+// byte big endian version followed by the exact bytes of the addresses
+// array as they appear in the file.
+type SignedWhitelist struct {
+	Version   uint64          `json:"version"`
+	Addresses json.RawMessage `json:"addresses"`
+	Signature string          `json:"signature"`
+}
+
+// fetchSignedWhitelist loads the whitelist from WhitelistURL, refusing
+// files not signed by WhitelistSigner and files older than the current
+// whitelist, so an old file cannot be replayed to restore revoked keys
+func (p *Proxy) fetchSignedWhitelist(ctx context.Context) ([]string, uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.WhitelistURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != 200 {
+		return nil, 0, fmt.Errorf("Response status %d", r.StatusCode)
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1000000))
+	file := &SignedWhitelist{}
+	err = decoder.Decode(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Response decode error")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(file.Signature, "0x"))
+	if err != nil || len(sig) != 65 || sig[64] > 1 {
+		return nil, 0, fmt.Errorf("Whitelist signature malformed")
+	}
+
+	version := make([]byte, 8)
+	binary.BigEndian.PutUint64(version, file.Version)
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(version)
+	hasher.Write(file.Addresses)
+	pubkey, err := secp256k1.RecoverPubkey(hasher.Sum(nil), sig)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Whitelist signature recovery error")
+	}
+
+	hasher.Reset()
+	hasher.Write(pubkey[1:])
+	signer := fmt.Sprintf("0x%x", hasher.Sum(nil)[12:])
+	if signer != strings.ToLower(p.WhitelistSigner) {
+		return nil, 0, fmt.Errorf("Whitelist signed by %s, expected %s", signer, p.WhitelistSigner)
+	}
+
+	current := atomic.LoadUint64(&p.whitelistVersion)
+	if file.Version < current {
+		return nil, 0, fmt.Errorf("Whitelist version %d is older than the current %d", file.Version, current)
+	}
+
+	var keys []string
+	err = json.Unmarshal(file.Addresses, &keys)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Whitelist addresses decode error")
+	}
+	// lookups compare lowercase addresses
+	for idx := range keys {
+		keys[idx] = strings.ToLower(keys[idx])
+	}
+
+	return keys, file.Version, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends the default client's subgraph requests to
+// target, as the subgraph URL is built in by fetchWhitelist
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt *redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host == "api.thegraph.com" {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = rt.target.Scheme
+		r.URL.Host = rt.target.Host
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// redirectDefaultClient points the subgraph at s for the test
+func redirectDefaultClient(t *testing.T, s *httptest.Server) {
+	t.Helper()
+	target, _ := url.Parse(s.URL)
+	prev := http.DefaultClient.Transport
+	http.DefaultClient.Transport = &redirectTransport{target}
+	t.Cleanup(func() {
+		http.DefaultClient.Transport = prev
+	})
+}
+
+// newSubgraph serves a keystores query answer with keys
+func newSubgraph(t *testing.T, gzipped bool, keys ...string) *httptest.Server {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `{"data":{"keystores":[`
+		for idx, key := range keys {
+			if idx > 0 {
+				body += ","
+			}
+			body += `{"key":"` + key + `"}`
+		}
+		body += `]}}`
+
+		w.Header().Set("Content-Type", "application/json")
+		if !gzipped {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(body))
+		gz.Close()
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestFetchWhitelistLowercasesSubgraphKeys(t *testing.T) {
+	redirectDefaultClient(t, newSubgraph(t, false, "0xAbCdEf0000000000000000000000000000000001"))
+	p := newTestProxy("")
+
+	keys, _, err := p.fetchWhitelist(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "0xabcdef0000000000000000000000000000000001" {
+		t.Fatalf("got %v", keys)
+	}
+}
+
+// newSignedWhitelist serves a whitelist file at version signed by key
+func newSignedWhitelist(t *testing.T, key []byte, version uint64, addresses string) *httptest.Server {
+	t.Helper()
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, version)
+	msg = append(msg, addresses...)
+	body := fmt.Sprintf(`{"version":%d,"addresses":%s,"signature":"%s"}`, version, addresses, keySign(t, key, msg))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSignedWhitelist(t *testing.T) {
+	s := newSignedWhitelist(t, testKey, 7, `["0xAbCdEf0000000000000000000000000000000001"]`)
+	p := newTestProxy("")
+	p.WhitelistURL = s.URL
+	p.WhitelistSigner = keyAddr(t, testKey)
+
+	keys, version, err := p.fetchWhitelist(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 7 || len(keys) != 1 || keys[0] != "0xabcdef0000000000000000000000000000000001" {
+		t.Fatalf("got %v at version %d", keys, version)
+	}
+
+	p.WhitelistSigner = keyAddr(t, otherKey)
+	_, _, err = p.fetchWhitelist(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "signed by") {
+		t.Fatalf("got %v, want a signer mismatch", err)
+	}
+}
+
+func TestSignedWhitelistRefusesOlderVersions(t *testing.T) {
+	s := newSignedWhitelist(t, testKey, 7, `["0x0000000000000000000000000000000000000001"]`)
+	p := newTestProxy("")
+	p.WhitelistURL = s.URL
+	p.WhitelistSigner = keyAddr(t, testKey)
+
+	// refetching the current file is fine
+	p.whitelistVersion = 7
+	if _, _, err := p.fetchWhitelist(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a replay of an older file, say one listing a revoked key
+	p.whitelistVersion = 8
+	_, _, err := p.fetchWhitelist(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "older") {
+		t.Fatalf("got %v, want an older version error", err)
+	}
+}
+
+func TestSignedWhitelistVersionIsSigned(t *testing.T) {
+	addresses := `["0x0000000000000000000000000000000000000001"]`
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, 7)
+	msg = append(msg, addresses...)
+	// signed at version 7, served claiming version 9
+	body := fmt.Sprintf(`{"version":9,"addresses":%s,"signature":"%s"}`, addresses, keySign(t, testKey, msg))
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+
+	p := newTestProxy("")
+	p.WhitelistURL = s.URL
+	p.WhitelistSigner = keyAddr(t, testKey)
+	if _, _, err := p.fetchWhitelist(context.Background()); err == nil {
+		t.Fatal("accepted a file with a bumped version")
+	}
+}
+
+func TestShrinkGuard(t *testing.T) {
+	p := newTestProxy("", "0x1", "0x2", "0x3", "0x4")
+
+	before := intVar("whitelistShrinkRejections")
+	if p.updateWhitelist([]string{"0x1"}, 0) {
+		t.Fatal("accepted a 75% shrink")
+	}
+	if intVar("whitelistShrinkRejections") != before+1 {
+		t.Fatal("rejection not counted")
+	}
+	if !p.updateWhitelist([]string{"0x1", "0x2"}, 0) {
+		t.Fatal("refused a 50% shrink")
+	}
+
+	w := httptest.NewRecorder()
+	p.handleAllowShrink(w, httptest.NewRequest("POST", "/admin/whitelist/allowShrink", nil))
+	if w.Code != 204 {
+		t.Fatalf("allowShrink status %d", w.Code)
+	}
+	if !p.updateWhitelist([]string{}, 0) {
+		t.Fatal("override did not let the shrink through")
+	}
+}
+
+func TestShrinkOverrideIsUsedUpByTheNextFetch(t *testing.T) {
+	p := newTestProxy("", "0x1", "0x2", "0x3", "0x4")
+
+	w := httptest.NewRecorder()
+	p.handleAllowShrink(w, httptest.NewRequest("POST", "/admin/whitelist/allowShrink", nil))
+
+	// the next fetch does not shrink, the override goes with it
+	if !p.updateWhitelist([]string{"0x1", "0x2", "0x3", "0x4"}, 0) {
+		t.Fatal("refused an unchanged whitelist")
+	}
+	if p.updateWhitelist([]string{"0x1"}, 0) {
+		t.Fatal("a stale override let a later shrink through")
+	}
+}
+
+func TestFetchWhitelistGzip(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		redirectDefaultClient(t, newSubgraph(t, gzipped,
+			"0x0000000000000000000000000000000000000001",
+			"0x0000000000000000000000000000000000000002"))
+		p := newTestProxy("")
+
+		keys, _, err := p.fetchWhitelist(context.Background())
+		if err != nil {
+			t.Fatalf("gzipped=%v: %s", gzipped, err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("gzipped=%v: got %v", gzipped, keys)
+		}
+	}
+}
+
+func TestFetchWhitelistBadGzip(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte(`{"data":{"keystores":[]}}`))
+	}))
+	defer s.Close()
+	redirectDefaultClient(t, s)
+
+	_, _, err := newTestProxy("").fetchWhitelist(context.Background())
+	if err == nil || err.Error() != "Response gzip error" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestWhitelistRetryBackoff(t *testing.T) {
+	hits := make(chan time.Time, 16)
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits <- time.Now()
+		if atomic.AddInt32(&count, 1) <= 5 {
+			w.WriteHeader(502)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"keystores":[{"key":"0x0000000000000000000000000000000000000001"}]}}`))
+	}))
+	defer s.Close()
+	redirectDefaultClient(t, s)
+
+	p := newTestProxy("")
+	p.WhitelistRetryDelay = 20 * time.Millisecond
+	p.WhitelistRefreshInterval = 80 * time.Millisecond
+	p.spawn(p.refreshWhitelist)
+
+	// the seventh fetch is the refresh after the first success
+	var times []time.Time
+	for len(times) < 7 {
+		times = append(times, <-hits)
+	}
+	p.Stop()
+
+	// doubling from the retry delay, capped at the refresh interval, then a
+	// regular refresh after the success
+	for idx, want := range []time.Duration{20, 40, 80, 80, 80, 80} {
+		want *= time.Millisecond
+		gap := times[idx+1].Sub(times[idx])
+		if gap < want*9/10 || (want == p.WhitelistRefreshInterval && gap >= 2*want*9/10) {
+			t.Errorf("retry %d after %s, want %s", idx+1, gap, want)
+		}
+	}
+	whitelist := (*map[string]struct{})(atomic.LoadPointer(&p.Whitelist))
+	if _, ok := (*whitelist)["0x0000000000000000000000000000000000000001"]; !ok {
+		t.Fatal("whitelist not updated once the fetch succeeded")
+	}
+}
+
+func TestWhitelistLookup(t *testing.T) {
+	upstream := newUpstream(t, func(req *RpcReq) interface{} {
+		return map[string]interface{}{"bundleHash": "0x01"}
+	})
+	p := newTestProxy(upstream.URL)
+
+	// nobody gets in on an empty whitelist
+	if w := postRpc(t, p, "eth_sendBundle", testBundle, testKey); w.Code != 400 {
+		t.Fatalf("empty whitelist: status %d", w.Code)
+	}
+
+	keys := []string{keyAddr(t, testKey)}
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("0x%040x", i))
+	}
+	p.updateWhitelist(keys, 0)
+	if w := postRpc(t, p, "eth_sendBundle", testBundle, testKey); w.Code != 200 {
+		t.Fatalf("whitelisted: status %d", w.Code)
+	}
+	if w := postRpc(t, p, "eth_sendBundle", testBundle, otherKey); w.Code != 400 {
+		t.Fatalf("not whitelisted: status %d", w.Code)
+	}
+}
+
+// BenchmarkWhitelistLookup compares the whitelist set with the binary
+// search over a sorted slice that it replaced
+func BenchmarkWhitelistLookup(b *testing.B) {
+	for _, size := range []int{100, 10000} {
+		keys := make([]string, 0, size)
+		set := make(map[string]struct{}, size)
+		for i := 0; i < size; i++ {
+			key := fmt.Sprintf("0x%040x", i*2)
+			keys = append(keys, key)
+			set[key] = struct{}{}
+		}
+		sort.Strings(keys)
+		// half hits, half misses
+		lookups := []string{keys[size/3], fmt.Sprintf("0x%040x", size+1)}
+
+		b.Run(fmt.Sprintf("map/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, ok := set[lookups[i%2]]
+				if ok != (i%2 == 0) {
+					b.Fatal("wrong lookup result")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("sorted/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				addr := lookups[i%2]
+				idx := sort.SearchStrings(keys, addr)
+				ok := idx < len(keys) && keys[idx] == addr
+				if ok != (i%2 == 0) {
+					b.Fatal("wrong lookup result")
+				}
+			}
+		})
+	}
+}